@@ -17,15 +17,61 @@ type binopNode struct {
 	op token
 }
 
+// walk calls fn for n and every node below it. The children of a node are
+// skipped when fn returns false.
+func walk(n node, fn func(node) bool) {
+	if n == nil || !fn(n) {
+		return
+	}
+
+	switch n := n.(type) {
+	case *binopNode:
+		walk(n.left, fn)
+		walk(n.right, fn)
+	case *unaryNode:
+		walk(n.operand, fn)
+	case *inNode:
+		walk(n.expr, fn)
+		for _, item := range n.list {
+			walk(item, fn)
+		}
+	case *betweenNode:
+		walk(n.expr, fn)
+		walk(n.low, fn)
+		walk(n.high, fn)
+	case *likeNode:
+		walk(n.expr, fn)
+		walk(n.pattern, fn)
+		walk(n.escape, fn)
+	case *aliasNode:
+		walk(n.expr, fn)
+	case *caseNode:
+		walk(n.operand, fn)
+		for _, w := range n.whens {
+			walk(w.cond, fn)
+			walk(w.result, fn)
+		}
+		walk(n.elseExpr, fn)
+	case *functionCallNode:
+		for _, arg := range n.args {
+			walk(arg, fn)
+		}
+	}
+}
+
 type functionCallNode struct {
-	args []node
-	name token
+	args     []node
+	name     token
+	distinct bool // only for aggregates, like COUNT(DISTINCT x)
 }
 
 func (f *functionCallNode) String() string {
 	var b strings.Builder
 	b.WriteString(f.name.content)
 	b.WriteByte('(')
+	if f.distinct {
+		b.WriteString("DISTINCT ")
+	}
 	for idx, arg := range f.args {
 		b.WriteString(arg.String())
 		if idx < len(f.args)-1 {
@@ -38,44 +84,403 @@ func (f *functionCallNode) String() string {
 }
 
 func (b *binopNode) String() string {
-	return b.left.String() + " " + b.op.content + " " + b.right.String()
+	prec := binaryPrecedence[b.op.tokType]
+	return operandString(b.left, prec) + " " + b.op.content + " " + operandString(b.right, prec+1)
+}
+
+// precedence returns how tight the operator at the top of n binds, operands
+// and function calls bind tightest.
+func precedence(n node) int {
+	switch n := n.(type) {
+	case *binopNode:
+		return binaryPrecedence[n.op.tokType]
+	case *unaryNode:
+		if n.op.tokType == notToken {
+			return notPrecedence
+		}
+		return unaryPrecedence
+	case *inNode, *betweenNode, *likeNode:
+		return predicatePrecedence
+	}
+	return unaryPrecedence + 1
+}
+
+// operandString wraps an operand in parentheses if it binds looser than the
+// operator it belongs to.
+func operandString(n node, minPrec int) string {
+	if precedence(n) < minPrec {
+		return "(" + n.String() + ")"
+	}
+	return n.String()
+}
+
+type unaryNode struct {
+	op      token
+	operand node
+}
+
+func (u *unaryNode) String() string {
+	if u.op.tokType == notToken {
+		return "NOT " + operandString(u.operand, notPrecedence)
+	}
+	return u.op.content + operandString(u.operand, unaryPrecedence)
+}
+
+// notKeyword is put in front of the keyword of negated predicates.
+func notKeyword(not bool) string {
+	if not {
+		return " NOT"
+	}
+	return ""
+}
+
+// inNode is expr [NOT] IN (list).
+type inNode struct {
+	expr node
+	list []node
+	not  bool
+}
+
+func (in *inNode) String() string {
+	items := make([]string, len(in.list))
+	for i, item := range in.list {
+		items[i] = item.String()
+	}
+	return fmt.Sprintf("%s%s IN (%s)", operandString(in.expr, predicatePrecedence+1),
+		notKeyword(in.not), strings.Join(items, ", "))
+}
+
+// betweenNode is expr [NOT] BETWEEN low AND high, both bounds are inclusive.
+type betweenNode struct {
+	expr, low, high node
+	not             bool
+}
+
+func (bn *betweenNode) String() string {
+	return fmt.Sprintf("%s%s BETWEEN %s AND %s", operandString(bn.expr, predicatePrecedence+1),
+		notKeyword(bn.not), operandString(bn.low, predicatePrecedence+1), operandString(bn.high, predicatePrecedence+1))
+}
+
+// likeNode is a LIKE, ILIKE or GLOB pattern match, op is the keyword.
+type likeNode struct {
+	op      token
+	expr    node
+	pattern node
+	escape  node // can be null, only for LIKE and ILIKE
+	not     bool
+
+	// the compiled pattern is kept for the next row, patterns are usually
+	// constant.
+	compiledFor string
+	compiled    []patternElem
+}
+
+func (ln *likeNode) String() string {
+	s := fmt.Sprintf("%s%s %s %s", operandString(ln.expr, predicatePrecedence+1),
+		notKeyword(ln.not), ln.op.content, operandString(ln.pattern, predicatePrecedence+1))
+	if ln.escape != nil {
+		s += " ESCAPE " + operandString(ln.escape, predicatePrecedence+1)
+	}
+	return s
+}
+
+type caseWhen struct {
+	cond   node
+	result node
+}
+
+// caseNode is a searched CASE WHEN cond THEN result ... END or, when operand is
+// set, a simple CASE operand WHEN value THEN result ... END.
+type caseNode struct {
+	operand  node // can be null
+	whens    []caseWhen
+	elseExpr node // can be null
+}
+
+func (c *caseNode) String() string {
+	var b strings.Builder
+	b.WriteString("CASE")
+	if c.operand != nil {
+		b.WriteString(" " + c.operand.String())
+	}
+	for _, w := range c.whens {
+		fmt.Fprintf(&b, " WHEN %s THEN %s", w.cond, w.result)
+	}
+	if c.elseExpr != nil {
+		b.WriteString(" ELSE " + c.elseExpr.String())
+	}
+	b.WriteString(" END")
+
+	return b.String()
+}
+
+// aliasNode names a column of the select list, like expr AS name.
+type aliasNode struct {
+	expr  node
+	alias token
+}
+
+func (a *aliasNode) String() string {
+	return a.expr.String() + " AS " + a.alias.content
+}
+
+// starNode is a * or t.* in the select list. It is expanded to the columns of
+// the FROM clause, without the excluded ones and with the replaced ones swapped
+// for their expressions.
+type starNode struct {
+	qualifier string // empty for a plain *
+	exclude   []string
+	replace   []*aliasNode
+}
+
+func (s *starNode) String() string {
+	var b strings.Builder
+	if s.qualifier != "" {
+		b.WriteString(s.qualifier + ".")
+	}
+	b.WriteByte('*')
+
+	if len(s.exclude) > 0 {
+		b.WriteString(" EXCLUDE (" + strings.Join(s.exclude, ", ") + ")")
+	}
+
+	if len(s.replace) > 0 {
+		items := make([]string, len(s.replace))
+		for i, r := range s.replace {
+			items[i] = r.String()
+		}
+		b.WriteString(" REPLACE (" + strings.Join(items, ", ") + ")")
+	}
+
+	return b.String()
+}
+
+// columnName is the name of a result column in the header of the response. It
+// is the alias if there is one, the name of a column reference without its
+// qualifier and the text of the expression otherwise.
+func columnName(n node) string {
+	switch n := n.(type) {
+	case *aliasNode:
+		return n.alias.content
+	case *literalNode:
+		if n.lit.tokType == identifierToken {
+			name := n.lit.content
+			return name[strings.LastIndexByte(name, '.')+1:]
+		}
+	}
+	return n.String()
 }
 
 type selectNode struct {
-	columns []node
-	from    token
-	where   node // can be null
+	distinct bool
+	columns  []node
+	from     node // a tableRefNode, valuesNode or joinNode, null without FROM
+	asOf     node // the time of AS OF SYSTEM TIME, can be null
+	where    node // can be null
+	groupBy  []node
+	having   node // can be null
+	orderBy  []orderByTerm
+	limit    node // can be null
+	offset   node // can be null
 }
 
 func (s *selectNode) String() string {
 	var b strings.Builder
 
-	b.WriteString("SELECT\n")
+	b.WriteString("SELECT")
+	if s.distinct {
+		b.WriteString(" DISTINCT")
+	}
+	b.WriteRune('\n')
 	for i, col := range s.columns {
 		b.WriteString("  ")
 		b.WriteString(col.String())
 		if i < len(s.columns)-1 {
-			b.WriteString(",")
+			b.WriteString(",\n")
 		}
-		b.WriteRune('\n')
 	}
 
-	b.WriteString("FROM\n")
-	b.WriteString("  " + s.from.content)
+	if s.from != nil {
+		b.WriteString("\nFROM\n")
+		b.WriteString("  " + s.from.String())
+	}
+	if s.asOf != nil {
+		b.WriteString("\nAS OF SYSTEM TIME " + s.asOf.String())
+	}
 	if s.where != nil {
 		b.WriteString("\nWHERE\n")
 		b.WriteString(s.where.String())
 	}
 
+	if len(s.groupBy) > 0 {
+		b.WriteString("\nGROUP BY\n")
+		for i, expr := range s.groupBy {
+			b.WriteString("  " + expr.String())
+			if i < len(s.groupBy)-1 {
+				b.WriteString(",\n")
+			}
+		}
+	}
+
+	if s.having != nil {
+		b.WriteString("\nHAVING\n")
+		b.WriteString(s.having.String())
+	}
+
+	if len(s.orderBy) > 0 {
+		b.WriteString("\nORDER BY\n")
+		for i, term := range s.orderBy {
+			b.WriteString("  " + term.String())
+			if i < len(s.orderBy)-1 {
+				b.WriteString(",\n")
+			}
+		}
+	}
+
+	if s.limit != nil {
+		b.WriteString("\nLIMIT " + s.limit.String())
+	}
+
+	if s.offset != nil {
+		b.WriteString("\nOFFSET " + s.offset.String())
+	}
+
 	b.WriteRune('\n')
 	return b.String()
 }
 
+type tableRefNode struct {
+	table token
+	alias token // empty without an alias
+}
+
+func (t *tableRefNode) String() string {
+	if t.alias.content != "" {
+		return t.table.content + " AS " + t.alias.content
+	}
+	return t.table.content
+}
+
+// name returns the name that the columns of the table are qualified with.
+func (t *tableRefNode) name() string {
+	if t.alias.content != "" {
+		return t.alias.content
+	}
+	return t.table.content
+}
+
+// valuesNode is a VALUES list, either as a statement of its own or as a table in
+// FROM where it needs an alias.
+type valuesNode struct {
+	rows    [][]node
+	alias   token   // empty for a VALUES statement
+	columns []token // names given after the alias
+}
+
+func (v *valuesNode) String() string {
+	var b strings.Builder
+	b.WriteString("VALUES ")
+	for i, r := range v.rows {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for j, val := range r {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(val.String())
+		}
+		b.WriteByte(')')
+	}
+
+	if v.alias.content == "" {
+		return b.String()
+	}
+
+	s := "(" + b.String() + ") AS " + v.alias.content
+	if len(v.columns) > 0 {
+		names := make([]string, 0, len(v.columns))
+		for _, col := range v.columns {
+			names = append(names, col.content)
+		}
+		s += " (" + strings.Join(names, ", ") + ")"
+	}
+	return s
+}
+
+// columnNames returns the names given after the alias, the columns without one
+// are called column1, column2 and so on.
+func (v *valuesNode) columnNames() []string {
+	var names []string
+	if len(v.rows) > 0 {
+		names = make([]string, len(v.rows[0]))
+	}
+
+	for i := range names {
+		if i < len(v.columns) {
+			names[i] = v.columns[i].content
+		} else {
+			names[i] = fmt.Sprintf("column%d", i+1)
+		}
+	}
+	return names
+}
+
+// joinKind is the kind of a join, which decides the rows of which sides are kept
+// when they have no match.
+type joinKind int
+
+const (
+	innerJoin joinKind = iota
+	leftJoin
+	rightJoin
+	fullJoin
+	crossJoin
+)
+
+// keepsLeft reports whether the rows of the left side without a match are kept
+// with NULLs for the right side.
+func (k joinKind) keepsLeft() bool {
+	return k == leftJoin || k == fullJoin
+}
+
+// keepsRight is keepsLeft for the right side.
+func (k joinKind) keepsRight() bool {
+	return k == rightJoin || k == fullJoin
+}
+
+var joinNames = [...]string{
+	innerJoin: "JOIN",
+	leftJoin:  "LEFT JOIN",
+	rightJoin: "RIGHT JOIN",
+	fullJoin:  "FULL JOIN",
+	crossJoin: "CROSS JOIN",
+}
+
+type joinNode struct {
+	kind  joinKind
+	left  node
+	right node
+	on    node // null for cross joins
+}
+
+func (j *joinNode) String() string {
+	s := j.left.String() + "\n  " + joinNames[j.kind] + " " + j.right.String()
+	if j.on != nil {
+		s += " ON " + j.on.String()
+	}
+	return s
+}
+
 type literalNode struct {
 	lit token
 }
 
 func (l *literalNode) String() string {
+	if l.lit.tokType == stringToken {
+		return "'" + l.lit.content + "'"
+	}
 	return l.lit.content
 }
 
@@ -85,8 +490,9 @@ type createTableColumn struct {
 }
 
 type createTableNode struct {
-	table   token
-	columns []createTableColumn
+	table      token
+	columns    []createTableColumn
+	primaryKey []token // a column level PRIMARY KEY is stored here as well
 }
 
 func (c *createTableNode) String() string {
@@ -96,32 +502,235 @@ func (c *createTableNode) String() string {
 
 	for i, col := range c.columns {
 		b.WriteString(col.name.content + " " + col.kind.content)
-		if i < len(c.columns)-1 {
+		if i < len(c.columns)-1 || len(c.primaryKey) > 0 {
 			b.WriteRune(',')
 		}
 		b.WriteRune('\n')
 	}
+
+	if len(c.primaryKey) > 0 {
+		names := make([]string, 0, len(c.primaryKey))
+		for _, col := range c.primaryKey {
+			names = append(names, col.content)
+		}
+		b.WriteString("PRIMARY KEY (" + strings.Join(names, ", ") + ")\n")
+	}
 	b.WriteString(")\n")
 	return b.String()
 }
 
 type insertNode struct {
-	table  token
-	values []node
+	table   token
+	columns []token // empty when the values are given for every column in order
+	rows    [][]node
+	query   *selectNode // set instead of rows for INSERT INTO ... SELECT
 }
 
 func (i *insertNode) String() string {
 	var b strings.Builder
 
-	b.WriteString(fmt.Sprintf("INSERT INTO %s VALUES(", i.table.content))
-	for idx, val := range i.values {
-		b.WriteString(val.String())
-		if idx < len(i.values)-1 {
+	b.WriteString("INSERT INTO " + i.table.content + " ")
+	if len(i.columns) > 0 {
+		names := make([]string, 0, len(i.columns))
+		for _, col := range i.columns {
+			names = append(names, col.content)
+		}
+		b.WriteString("(" + strings.Join(names, ", ") + ") ")
+	}
+
+	if i.query != nil {
+		b.WriteString(i.query.String())
+		return b.String()
+	}
+
+	b.WriteString("VALUES")
+	for r, values := range i.rows {
+		if r > 0 {
 			b.WriteRune(',')
 		}
+		b.WriteRune('(')
+		for idx, val := range values {
+			b.WriteString(val.String())
+			if idx < len(values)-1 {
+				b.WriteRune(',')
+			}
+		}
+		b.WriteRune(')')
 	}
 
-	b.WriteString(")\n")
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+type updateAssignment struct {
+	column token
+	value  node
+}
+
+type updateNode struct {
+	table       token
+	assignments []updateAssignment
+	where       node // can be null
+}
+
+func (u *updateNode) String() string {
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("UPDATE %s SET\n", u.table.content))
+	for i, a := range u.assignments {
+		b.WriteString("  " + a.column.content + " = " + a.value.String())
+		if i < len(u.assignments)-1 {
+			b.WriteRune(',')
+		}
+		b.WriteRune('\n')
+	}
+
+	if u.where != nil {
+		b.WriteString("WHERE\n")
+		b.WriteString(u.where.String())
+		b.WriteRune('\n')
+	}
+
+	return b.String()
+}
+
+type deleteNode struct {
+	table token
+	where node // can be null
+}
+
+func (d *deleteNode) String() string {
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("DELETE FROM %s\n", d.table.content))
+	if d.where != nil {
+		b.WriteString("WHERE\n")
+		b.WriteString(d.where.String())
+		b.WriteRune('\n')
+	}
+
+	return b.String()
+}
+
+type dropTableNode struct {
+	table    token
+	ifExists bool
+}
+
+func (d *dropTableNode) String() string {
+	if d.ifExists {
+		return fmt.Sprintf("DROP TABLE IF EXISTS %s\n", d.table.content)
+	}
+	return fmt.Sprintf("DROP TABLE %s\n", d.table.content)
+}
+
+type createIndexNode struct {
+	name    token
+	table   token
+	unique  bool
+	columns []token
+	include []token
+}
+
+func joinTokens(toks []token) string {
+	names := make([]string, 0, len(toks))
+	for _, t := range toks {
+		names = append(names, t.content)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (c *createIndexNode) String() string {
+	var b strings.Builder
+
+	b.WriteString("CREATE ")
+	if c.unique {
+		b.WriteString("UNIQUE ")
+	}
+	b.WriteString(fmt.Sprintf("INDEX %s ON %s (%s)", c.name.content, c.table.content, joinTokens(c.columns)))
+	if len(c.include) > 0 {
+		b.WriteString(" INCLUDE (" + joinTokens(c.include) + ")")
+	}
+	b.WriteRune('\n')
+	return b.String()
+}
+
+type dropIndexNode struct {
+	name     token
+	ifExists bool
+}
+
+func (d *dropIndexNode) String() string {
+	if d.ifExists {
+		return fmt.Sprintf("DROP INDEX IF EXISTS %s\n", d.name.content)
+	}
+	return fmt.Sprintf("DROP INDEX %s\n", d.name.content)
+}
+
+type truncateTableNode struct {
+	table token
+}
+
+func (t *truncateTableNode) String() string {
+	return fmt.Sprintf("TRUNCATE TABLE %s\n", t.table.content)
+}
+
+// transactionNode is a BEGIN, COMMIT or ROLLBACK statement, action is the token
+// type of the keyword.
+type transactionNode struct {
+	action   int
+	readOnly bool // only for BEGIN
+}
+
+func (t *transactionNode) String() string {
+	switch t.action {
+	case beginToken:
+		if t.readOnly {
+			return "BEGIN READ ONLY\n"
+		}
+		return "BEGIN\n"
+	case commitToken:
+		return "COMMIT\n"
+	default:
+		return "ROLLBACK\n"
+	}
+}
+
+const (
+	addColumnAction int = iota
+	dropColumnAction
+	renameColumnAction
+	renameTableAction
+)
+
+type alterTableNode struct {
+	table   token
+	action  int
+	column  token // the column that is added, dropped or renamed
+	kind    token // type of an added column
+	def     node  // default of an added column, can be null
+	newName token // new name of the column or table when renaming
+}
+
+func (a *alterTableNode) String() string {
+	var b strings.Builder
+
+	b.WriteString(fmt.Sprintf("ALTER TABLE %s ", a.table.content))
+	switch a.action {
+	case addColumnAction:
+		b.WriteString("ADD COLUMN " + a.column.content + " " + a.kind.content)
+		if a.def != nil {
+			b.WriteString(" DEFAULT " + a.def.String())
+		}
+	case dropColumnAction:
+		b.WriteString("DROP COLUMN " + a.column.content)
+	case renameColumnAction:
+		b.WriteString("RENAME COLUMN " + a.column.content + " TO " + a.newName.content)
+	case renameTableAction:
+		b.WriteString("RENAME TO " + a.newName.content)
+	}
+	b.WriteRune('\n')
 
 	return b.String()
 }
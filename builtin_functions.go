@@ -19,6 +19,9 @@ var builtinFuncs = map[string]builtinFunc{
 	"equal_fold":    builtinEqualFold,
 	"string_repeat": builtinStringRepeat,
 	"concat":        builtinConcat,
+	"coalesce":      builtinCoalesce,
+	"nullif":        builtinNullIf,
+	"iif":           builtinIif,
 }
 
 func executeArgs(exec expressionExecutor, row *row, args []node) ([]value, error) {
@@ -35,14 +38,25 @@ func executeArgs(exec expressionExecutor, row *row, args []node) ([]value, error
 	return values, nil
 }
 
+// hasNull reports whether any of the arguments is NULL. Scalar functions return
+// NULL for NULL arguments, an unknown input gives an unknown result.
+func hasNull(vals []value) bool {
+	for _, v := range vals {
+		if v.ty == nullVal {
+			return true
+		}
+	}
+	return false
+}
+
 func builtinLower(exec expressionExecutor, row *row, args []node) (value, error) {
 	if len(args) != 1 {
 		return value{}, fmt.Errorf("lower takes 1 argument, got: %d", len(args))
 	}
 
 	valToLower, err := exec.executeExpression(args[0], row)
-	if err != nil {
-		return value{}, err
+	if err != nil || valToLower.ty == nullVal {
+		return valToLower, err
 	}
 
 	return value{
@@ -57,8 +71,8 @@ func builtinUpper(exec expressionExecutor, row *row, args []node) (value, error)
 	}
 
 	valToLower, err := exec.executeExpression(args[0], row)
-	if err != nil {
-		return value{}, err
+	if err != nil || valToLower.ty == nullVal {
+		return valToLower, err
 	}
 
 	return value{
@@ -77,6 +91,10 @@ func builtinEqualFold(exec expressionExecutor, row *row, args []node) (value, er
 		return value{}, err
 	}
 
+	if hasNull(vals) {
+		return value{ty: nullVal}, nil
+	}
+
 	return value{
 		boolVal: strings.EqualFold(vals[0].asStr(), vals[1].asStr()),
 		ty:      boolVal,
@@ -93,6 +111,14 @@ func builtinStringRepeat(exec expressionExecutor, row *row, args []node) (value,
 		return value{}, err
 	}
 
+	if hasNull(vals) {
+		return value{ty: nullVal}, nil
+	}
+
+	if vals[1].asInt() < 0 {
+		return value{}, fmt.Errorf("string_repeat count cannot be negative: %d", vals[1].asInt())
+	}
+
 	return value{
 		stringVal: strings.Repeat(vals[0].asStr(), int(vals[1].asInt())),
 		ty:        stringVal,
@@ -109,8 +135,274 @@ func builtinConcat(exec expressionExecutor, row *row, args []node) (value, error
 		return value{}, err
 	}
 
+	if hasNull(vals) {
+		return value{ty: nullVal}, nil
+	}
+
 	return value{
 		stringVal: vals[0].asStr() + vals[1].asStr(),
 		ty:        stringVal,
 	}, nil
 }
+
+// builtinCoalesce returns the first argument that isn't NULL. The arguments
+// after it are never evaluated.
+func builtinCoalesce(exec expressionExecutor, row *row, args []node) (value, error) {
+	if len(args) == 0 {
+		return value{}, fmt.Errorf("coalesce takes at least 1 argument")
+	}
+
+	for _, arg := range args {
+		val, err := exec.executeExpression(arg, row)
+		if err != nil || val.ty != nullVal {
+			return val, err
+		}
+	}
+
+	return value{ty: nullVal}, nil
+}
+
+// builtinNullIf returns NULL if both arguments are equal and the first one
+// otherwise.
+func builtinNullIf(exec expressionExecutor, row *row, args []node) (value, error) {
+	if len(args) != 2 {
+		return value{}, fmt.Errorf("nullif takes 2 arguments, got: %d", len(args))
+	}
+
+	vals, err := executeArgs(exec, row, args)
+	if err != nil {
+		return value{}, err
+	}
+
+	if vals[0].ty != nullVal && vals[1].ty != nullVal && compareValues(vals[0], vals[1]) == 0 {
+		return value{ty: nullVal}, nil
+	}
+	return vals[0], nil
+}
+
+// builtinIif evaluates only the argument picked by the condition.
+func builtinIif(exec expressionExecutor, row *row, args []node) (value, error) {
+	if len(args) != 3 {
+		return value{}, fmt.Errorf("iif takes 3 arguments, got: %d", len(args))
+	}
+
+	cond, err := exec.executeExpression(args[0], row)
+	if err != nil {
+		return value{}, err
+	}
+
+	if cond.asBool() {
+		return exec.executeExpression(args[1], row)
+	}
+	return exec.executeExpression(args[2], row)
+}
+
+// aggregateState accumulates the values of a single group. step is called once
+// for every row in the group and finalize once after the last row.
+type aggregateState interface {
+	step(args []value) error
+	finalize() value
+}
+
+// aggregateFunc creates the initial state of an aggregate for a new group. The
+// number of arguments is checked against minArgs and maxArgs when the select is
+// planned, so step can rely on it even if no row reaches it.
+type aggregateFunc struct {
+	new              func() aggregateState
+	minArgs, maxArgs int // maxArgs is -1 if there is no limit
+}
+
+var aggregateFuncs = map[string]aggregateFunc{
+	"count":        {newCountAggregate, 1, -1},
+	"sum":          {newSumAggregate, 1, 1},
+	"avg":          {newAvgAggregate, 1, 1},
+	"min":          {newMinAggregate, 1, 1},
+	"max":          {newMaxAggregate, 1, 1},
+	"group_concat": {newGroupConcatAggregate, 1, 2},
+}
+
+// checkAggregateArgs returns an error if the aggregate is called with the wrong
+// number of arguments.
+func checkAggregateArgs(fcn *functionCallNode) error {
+	name := strings.ToLower(fcn.name.content)
+	agg, n := aggregateFuncs[name], len(fcn.args)
+	switch {
+	case agg.maxArgs == -1 && n < agg.minArgs:
+		return fmt.Errorf("%s takes at least %d argument, got: %d", name, agg.minArgs, n)
+	case agg.maxArgs == -1:
+		return nil
+	case agg.minArgs == agg.maxArgs && n != agg.minArgs:
+		return fmt.Errorf("%s takes %d argument, got: %d", name, agg.minArgs, n)
+	case n < agg.minArgs || n > agg.maxArgs:
+		return fmt.Errorf("%s takes %d or %d arguments, got: %d", name, agg.minArgs, agg.maxArgs, n)
+	}
+	return nil
+}
+
+func isAggregate(fcn *functionCallNode) bool {
+	_, ok := aggregateFuncs[strings.ToLower(fcn.name.content)]
+	return ok
+}
+
+// isStar reports whether the node is the * in COUNT(*).
+func isStar(n node) bool {
+	lit, ok := n.(*literalNode)
+	return ok && lit.lit.tokType == starToken
+}
+
+// distinctAggregate only passes the first occurrence of every argument tuple
+// to the wrapped aggregate, it implements the DISTINCT in COUNT(DISTINCT x).
+type distinctAggregate struct {
+	inner aggregateState
+	seen  *distinctSet
+}
+
+func (d *distinctAggregate) step(args []value) error {
+	isNew, err := d.seen.add(valuesKey(args))
+	if err != nil || !isNew {
+		return err
+	}
+
+	return d.inner.step(args)
+}
+
+func (d *distinctAggregate) finalize() value {
+	return d.inner.finalize()
+}
+
+func (d *distinctAggregate) Close() error {
+	return d.seen.Close()
+}
+
+// countAggregate counts rows for COUNT(*), which steps without arguments, and
+// non-null values otherwise.
+type countAggregate struct {
+	count int64
+}
+
+func newCountAggregate() aggregateState { return &countAggregate{} }
+
+func (c *countAggregate) step(args []value) error {
+	for _, arg := range args {
+		if arg.ty == nullVal {
+			return nil
+		}
+	}
+
+	c.count++
+	return nil
+}
+
+func (c *countAggregate) finalize() value {
+	return value{ty: integerVal, integerVal: c.count}
+}
+
+type sumAggregate struct {
+	name  string
+	sum   int64
+	count int64
+}
+
+func newSumAggregate() aggregateState { return &sumAggregate{name: "sum"} }
+
+// step only adds up integers, there is no sensible sum of strings.
+func (s *sumAggregate) step(args []value) error {
+	v := args[0]
+	if v.ty == nullVal {
+		return nil
+	}
+	if v.ty != integerVal {
+		return fmt.Errorf("%s takes integers, got: %q", s.name, v.asStr())
+	}
+
+	s.sum += v.integerVal
+	s.count++
+	return nil
+}
+
+// finalize returns NULL for a sum of no values like SQL does.
+func (s *sumAggregate) finalize() value {
+	if s.count == 0 {
+		return value{ty: nullVal}
+	}
+	return value{ty: integerVal, integerVal: s.sum}
+}
+
+// avgAggregate truncates the average to an integer since there are no floating
+// point values.
+type avgAggregate struct {
+	sumAggregate
+}
+
+func newAvgAggregate() aggregateState { return &avgAggregate{sumAggregate{name: "avg"}} }
+
+func (a *avgAggregate) finalize() value {
+	if a.count == 0 {
+		return value{ty: nullVal}
+	}
+	return value{ty: integerVal, integerVal: a.sum / a.count}
+}
+
+// extremeAggregate implements MIN when sign is 1 and MAX when sign is -1.
+type extremeAggregate struct {
+	sign int
+	best value
+}
+
+func newMinAggregate() aggregateState {
+	return &extremeAggregate{sign: 1, best: value{ty: nullVal}}
+}
+
+func newMaxAggregate() aggregateState {
+	return &extremeAggregate{sign: -1, best: value{ty: nullVal}}
+}
+
+func (m *extremeAggregate) step(args []value) error {
+	v := args[0]
+	if v.ty == nullVal {
+		return nil
+	}
+
+	if m.best.ty == nullVal || compareValues(v, m.best)*m.sign < 0 {
+		m.best = v
+	}
+	return nil
+}
+
+func (m *extremeAggregate) finalize() value {
+	return m.best
+}
+
+// groupConcatAggregate joins the non-null values with the optional second
+// argument, which defaults to a comma.
+type groupConcatAggregate struct {
+	b     strings.Builder
+	count int
+}
+
+func newGroupConcatAggregate() aggregateState { return &groupConcatAggregate{} }
+
+func (g *groupConcatAggregate) step(args []value) error {
+	if args[0].ty == nullVal {
+		return nil
+	}
+
+	if g.count > 0 {
+		if len(args) == 2 {
+			g.b.WriteString(args[1].asStr())
+		} else {
+			g.b.WriteByte(',')
+		}
+	}
+
+	g.b.WriteString(args[0].asStr())
+	g.count++
+	return nil
+}
+
+func (g *groupConcatAggregate) finalize() value {
+	if g.count == 0 {
+		return value{ty: nullVal}
+	}
+	return value{ty: stringVal, stringVal: g.b.String()}
+}
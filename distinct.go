@@ -0,0 +1,73 @@
+package levelsql
+
+// distinctKeyOverhead approximates the memory used by a map entry on top of the
+// bytes of the key itself.
+const distinctKeyOverhead = 48
+
+// distinctSet tracks which keys have been seen. The keys are kept in memory
+// until they use more than the memory budget, after that they are moved to a
+// scratch space and new keys are checked against both.
+type distinctSet struct {
+	storage storage
+	budget  int
+	used    int
+	keys    map[string]struct{}
+	scratch scratchSpace // null until the set has spilled
+}
+
+func (e *exec) newDistinctSet() *distinctSet {
+	return &distinctSet{
+		storage: e.storage,
+		budget:  e.budget(),
+		keys:    make(map[string]struct{}),
+	}
+}
+
+// add returns true if the key wasn't in the set before.
+func (d *distinctSet) add(key string) (bool, error) {
+	if _, ok := d.keys[key]; ok {
+		return false, nil
+	}
+
+	if d.scratch != nil {
+		seen, err := d.scratch.has([]byte(key))
+		if err != nil || seen {
+			return false, err
+		}
+	}
+
+	d.keys[key] = struct{}{}
+	d.used += len(key) + distinctKeyOverhead
+	if d.used > d.budget {
+		return true, d.spill()
+	}
+
+	return true, nil
+}
+
+func (d *distinctSet) spill() error {
+	if d.scratch == nil {
+		scratch, err := d.storage.newScratch()
+		if err != nil {
+			return err
+		}
+		d.scratch = scratch
+	}
+
+	for key := range d.keys {
+		if err := d.scratch.put([]byte(key), nil); err != nil {
+			return err
+		}
+	}
+
+	d.keys = make(map[string]struct{})
+	d.used = 0
+	return nil
+}
+
+func (d *distinctSet) Close() error {
+	if d.scratch != nil {
+		return d.scratch.Close()
+	}
+	return nil
+}
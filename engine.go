@@ -1,6 +1,7 @@
 package levelsql
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
@@ -8,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
@@ -119,21 +121,109 @@ func deserializeValue(b []byte) value {
 	}
 }
 
+// valuesKey encodes a tuple of values into a string usable as a map key. The
+// encoding is typed, so the integer 1 and the string '1' get different keys.
+func valuesKey(vals []value) string {
+	var b []byte
+	for _, v := range vals {
+		b = appendBytes(b, v.bytes())
+	}
+	return string(b)
+}
+
 type storageIterator interface {
 	Next() (*row, bool)
 	Close() error
 }
 
+// storageBatch collects row writes that are applied atomically on commit. Writes
+// that would give two rows of a table the same primary key fail right away.
+type storageBatch interface {
+	insertRow(table string, row *row) error
+	updateRow(table string, old, updated *row) error
+	deleteRow(table string, row *row)
+
+	// indexRow adds the entry of an existing row to an index, which fills a new
+	// index together with writeTable.
+	indexRow(table string, ix *index, row *row) error
+	writeTable(t *table)
+	commit() error
+}
+
 type storage interface {
 	getTable(name string) (*table, error)
 	writeRow(table string, row *row) error
 	writeTable(table *table) error
 	getRowIterator(table string) (storageIterator, error)
+
+	// getRowRange iterates the rows of a table stored under the keys in
+	// [start, limit) and getRow reads the row stored under key, it returns nil if
+	// there is no such row. Both read the rows as of the commit timestamp ts,
+	// latestTimestamp reads the newest ones.
+	getRowRange(table string, start, limit []byte, ts uint64) (storageIterator, error)
+	getRow(table string, key []byte, ts uint64) (*row, error)
+
+	// writeRowAt stores a version of the row with the commit timestamp ts, the
+	// row is stored under row.key if it has one. Like writeRow it leaves the
+	// indexes as they are.
+	writeRowAt(table string, row *row, ts uint64) error
+
+	// collectGarbage removes the row versions that reads at or after before
+	// don't need and returns how many there were.
+	collectGarbage(before uint64) (int, error)
+
+	// getIndexRange iterates the rows of the index entries with keys in
+	// [start, limit). A covering scan builds the rows from the entries, all
+	// columns that are not in the index are NULL.
+	getIndexRange(table string, ix *index, start, limit []byte, covering bool) (storageIterator, error)
+	findIndex(name string) (*table, error)
+	dropIndex(tbl *table, name string) error
+	renameTable(oldName, newName string) error
+	dropTable(table string) error
+	truncateTable(table string) error
+	newBatch() storageBatch
+	newScratch() (scratchSpace, error)
+
+	// tableSize estimates the bytes the rows of a table take on disk, the
+	// planner uses it to compare the sizes of join inputs.
+	tableSize(table string) (int64, error)
+
+	// begin starts a statement or a transaction in one of the tx modes, the
+	// storage it returns reads from a snapshot taken now.
+	begin(mode int) (txStorage, error)
+	Close() error
+}
+
+type txStorage interface {
+	storage
+	commit() error
+	rollback()
+}
+
+// scratchSpace is a temporary keyspace for operators whose state doesn't fit
+// into memory. Closing it removes all of its keys.
+type scratchSpace interface {
+	put(key, value []byte) error
+	has(key []byte) (bool, error)
+	// scan iterates the values of the keys starting with prefix in key order.
+	scan(prefix []byte) iterator.Iterator
 	Close() error
 }
 
+var errNoSuchTable = errors.New("no such table")
+var errNoSuchIndex = errors.New("no such index")
+
+// deleteBatchSize is the amount of keys removed per write when deleting whole
+// key ranges, so that dropping a big table doesn't build one huge batch.
+const deleteBatchSize = 1000
+
 type leveldbStorage struct {
-	db *leveldb.DB
+	db    *leveldb.DB
+	clock *versionClock
+
+	// kv is what the rows and the catalog are read from and written to, the
+	// database itself or a transaction on top of it.
+	kv kvStore
 }
 
 type leveldbRowIterator struct {
@@ -146,7 +236,22 @@ func NewStorage(dbPath string) (storage, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &leveldbStorage{db: db}, nil
+
+	clock, err := openClock(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &leveldbStorage{db: db, clock: clock, kv: newMVCCStore(clock)}
+	// scratch keys are normally removed when the query finishes, but a crash
+	// can leave them behind.
+	if err := deleteRange(db, []byte(scratchPrefix)); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
 }
 
 func (s *leveldbStorage) Close() error {
@@ -156,6 +261,11 @@ func (s *leveldbStorage) Close() error {
 type row struct {
 	table *table
 	Cells []value
+	key   []byte // storage key of the row, set when read through an iterator
+
+	// aggregates holds the results of the aggregate calls when the row
+	// represents a group.
+	aggregates map[*functionCallNode]value
 }
 
 // rows are often allocated a lot so reduce the amount of allocations
@@ -175,6 +285,8 @@ func getRow() *row {
 func putRow(r *row) {
 	r.table = nil
 	r.Cells = r.Cells[:0]
+	r.key = r.key[:0]
+	r.aggregates = nil
 	rowPool.Put(r)
 }
 
@@ -198,22 +310,28 @@ func (r *row) Append(v value) {
 	r.Cells = append(r.Cells, v)
 }
 
+// Get returns the value of a column. The field can be qualified with the table
+// name or alias, like "u.name".
 func (r *row) Get(field string) value {
-	for i, f := range r.table.Columns {
-		if f == field {
-			return r.Cells[i]
-		}
+	if r.table == nil {
+		return value{ty: nullVal}
+	}
+
+	if i := r.table.fieldIndex(field); i != -1 && i < len(r.Cells) {
+		return r.Cells[i]
 	}
 	return value{ty: nullVal}
 }
 
-func (s *leveldbStorage) writeRow(table string, row *row) error {
-	key := make([]byte, 16)
-	rand.Read(key)
-	keyPrefix := fmt.Sprintf("row_%s_", table)
-	fullKey := append([]byte(keyPrefix), key...)
-
+// encodeRow stamps the row with the schema version of its table, unless the table
+// was never altered. That keeps rows of such tables in the original format.
+func encodeRow(row *row) []byte {
 	var value []byte
+	if row.table != nil && row.table.Version > 0 {
+		value = append(value, versionedRowMarker)
+		value = appendUint(value, uint64(row.table.Version))
+	}
+
 	for _, cell := range row.Cells {
 		cellBytes := cell.bytes()
 		lenBytes := make([]byte, 8)
@@ -222,342 +340,1809 @@ func (s *leveldbStorage) writeRow(table string, row *row) error {
 		value = append(value, cellBytes...)
 	}
 
-	return s.db.Put(fullKey, value, nil)
+	return value
 }
 
-func (ri *leveldbRowIterator) Next() (*row, bool) {
-	if !ri.iter.Next() {
-		return nil, false
+// decodeRow appends the cells in value to the row. Rows written in an older
+// schema version are mapped to the current columns of the row's table.
+func decodeRow(row *row, value []byte) {
+	version := 0
+	if len(value) > 0 && value[0] == versionedRowMarker {
+		version = int(binary.BigEndian.Uint64(value[1:9]))
+		value = value[9:]
 	}
-	value := ri.iter.Value()
-	row := newRow(ri.table)
 
+	layout := row.table.layout(version)
+	if layout != nil {
+		row.Cells = append(row.Cells, row.table.Defaults...)
+	}
+	decodeCells(row, value, layout)
+}
+
+// decodeCells decodes the cells of a row without a version stamp. A nil layout
+// appends the cells in their stored order.
+func decodeCells(row *row, value []byte, layout []int) {
 	offset := 0
-	for offset < len(value) {
+	for i := 0; offset < len(value); i++ {
 		cellLen := binary.BigEndian.Uint64(value[offset : offset+8])
 		offset += 8
 		cellData := value[offset : offset+int(cellLen)]
-		row.Append(deserializeValue(cellData))
 		offset += int(cellLen)
-	}
 
-	return row, true
+		if layout == nil {
+			row.Append(deserializeValue(cellData))
+		} else if i < len(layout) && layout[i] != -1 {
+			row.Cells[layout[i]] = deserializeValue(cellData)
+		}
+	}
 }
 
-func (ri *leveldbRowIterator) Close() error {
-	ri.iter.Release()
-	return nil
+// newRowKey returns a random key for a new row of the table.
+func newRowKey(t *table) []byte {
+	key := make([]byte, 16)
+	rand.Read(key)
+	return append(rowPrefix(t), key...)
 }
 
-func (s *leveldbStorage) getRowIterator(table string) (storageIterator, error) {
-	prefix := []byte(fmt.Sprintf("row_%s_", table))
-	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
-
-	tableInfo, err := s.getTable(table)
-	if err != nil {
-		iter.Release()
-		return nil, err
+// primaryKeyOf returns the key of a row of a table with a primary key, which is
+// the encoded tuple of its key columns. It returns nil for other tables.
+func primaryKeyOf(t *table, row *row) ([]byte, error) {
+	if t == nil || len(t.PrimaryKey) == 0 {
+		return nil, nil
 	}
 
-	return &leveldbRowIterator{
-		table: tableInfo,
-		iter:  iter,
-	}, nil
-}
+	key := rowPrefix(t)
+	for _, idx := range t.primaryKey() {
+		v := value{ty: nullVal}
+		if idx < len(row.Cells) {
+			v = row.Cells[idx]
+		}
 
-type table struct {
-	Name    string
-	Columns []string
-	Types   []string
+		if v.ty == nullVal {
+			return nil, fmt.Errorf("primary key column cannot be NULL: %s", t.Columns[idx])
+		}
+		key = appendKeyValue(key, v)
+	}
+	return key, nil
 }
 
-func (s *leveldbStorage) writeTable(table *table) error {
-	key := []byte(fmt.Sprintf("tbl_%s_", table.Name))
-	var value []byte
-	for i, column := range table.Columns {
-		colLenBytes := make([]byte, 8)
-		binary.BigEndian.PutUint64(colLenBytes, uint64(len(column)))
-		value = append(value, colLenBytes...)
-		value = append(value, column...)
+// valuesString formats the values of the given columns for error messages.
+func valuesString(row *row, positions []int) string {
+	var vals []string
+	for _, idx := range positions {
+		vals = append(vals, cell(row, idx).asStr())
+	}
+	return "(" + strings.Join(vals, ", ") + ")"
+}
 
-		typeBytes := []byte(table.Types[i])
-		typeLenBytes := make([]byte, 8)
-		binary.BigEndian.PutUint64(typeLenBytes, uint64(len(typeBytes)))
-		value = append(value, typeLenBytes...)
-		value = append(value, typeBytes...)
+// cell returns the value of the column at idx, a row written before the column
+// was added can be shorter.
+func cell(row *row, idx int) value {
+	if idx < len(row.Cells) {
+		return row.Cells[idx]
 	}
+	return value{ty: nullVal}
+}
 
-	return s.db.Put(key, value, nil)
+// indexPrefix is the start of the keys of an index. The id has a fixed width, so
+// the keys of one index never start with the prefix of another.
+func indexPrefix(t *table, id int) []byte {
+	return binary.BigEndian.AppendUint64(tablePrefix("idx", t), uint64(id))
 }
 
-func (s *leveldbStorage) getTable(name string) (*table, error) {
-	key := []byte(fmt.Sprintf("tbl_%s_", name))
-	value, err := s.db.Get(key, nil)
-	if err == leveldb.ErrNotFound {
-		return nil, fmt.Errorf("no such table")
-	} else if err != nil {
-		return nil, err
+// indexEntry returns the key of the entry of a row in an index and its value,
+// which is the row key without the table prefix followed by the values of the
+// indexed and included columns. The row key is appended to the key as well,
+// unless the index is unique and the key has no NULLs, which never count as
+// duplicates.
+func indexEntry(ix *index, row *row) (key []byte, unique bool, val []byte) {
+	suffix := row.key[len(rowPrefix(row.table)):]
+
+	key = indexPrefix(row.table, ix.ID)
+	unique = ix.Unique
+	for _, idx := range row.table.positions(ix.Columns) {
+		v := cell(row, idx)
+		unique = unique && v.ty != nullVal
+		key = appendKeyValue(key, v)
 	}
-
-	table := &table{
-		Name:    name,
-		Columns: make([]string, 0),
-		Types:   make([]string, 0),
+	if !unique {
+		key = append(key, suffix...)
 	}
 
-	offset := 0
-	for offset < len(value) {
-		colLen := binary.BigEndian.Uint64(value[offset : offset+8])
-		offset += 8
-		column := value[offset : offset+int(colLen)]
-		offset += int(colLen)
-		table.Columns = append(table.Columns, string(column))
-
-		typeLen := binary.BigEndian.Uint64(value[offset : offset+8])
-		offset += 8
-		colType := string(value[offset : offset+int(typeLen)])
-		offset += int(typeLen)
-		table.Types = append(table.Types, colType)
+	val = appendBytes(nil, suffix)
+	for _, idx := range row.table.positions(indexColumns(ix)) {
+		val = appendBytes(val, cell(row, idx).bytes())
 	}
-
-	return table, nil
-}
-
-type exec struct {
-	storage storage
-}
-
-type QueryResponse struct {
-	fields []string
-	rows   [][]string
-	empty  bool
+	return key, unique, val
 }
 
-func (qr *QueryResponse) String() string {
-	if qr.empty {
-		return "ok"
+func (s *leveldbStorage) writeRow(table string, row *row) error {
+	tbl, err := s.getTable(table)
+	if err != nil {
+		return err
 	}
 
-	var b strings.Builder
-	b.WriteString("| ")
+	key, err := primaryKeyOf(tbl, row)
+	if err != nil {
+		return err
+	}
 
-	for _, field := range qr.fields {
-		b.WriteString(field)
-		b.WriteString("\t\t|")
+	if key == nil {
+		key = newRowKey(tbl)
 	}
-	b.WriteByte('\n')
+	return s.kv.Put(key, encodeRow(row), nil)
+}
 
-	b.WriteString("+ ")
-	for _, field := range qr.fields {
-		b.WriteString(strings.Repeat("=", len(field)) + "\t\t+")
+func (s *leveldbStorage) writeRowAt(table string, row *row, ts uint64) error {
+	tbl, err := s.getTable(table)
+	if err != nil {
+		return err
 	}
-	b.WriteByte('\n')
 
-	for _, row := range qr.rows {
-		b.WriteString("| ")
-		for _, cell := range row {
-			b.WriteString(cell)
-			b.WriteString("\t\t|")
+	key := row.key
+	if key == nil {
+		if key, err = primaryKeyOf(tbl, row); err != nil {
+			return err
 		}
-		b.WriteByte('\n')
 	}
 
-	return b.String()
+	if key == nil {
+		key = newRowKey(tbl)
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put(key, encodeRow(row))
+	return s.clock.write(batch, ts)
 }
 
-func (e *exec) executeBinop(binop *binopNode, row *row) (value, error) {
-	lhs, err := e.executeExpression(binop.left, row)
-	if err != nil {
-		return value{}, nil
-	}
+func (s *leveldbStorage) collectGarbage(before uint64) (int, error) {
+	return collectGarbage(s.db, before)
+}
 
-	rhs, err := e.executeExpression(binop.right, row)
-	if err != nil {
-		return value{}, nil
-	}
+type leveldbBatch struct {
+	storage *leveldbStorage
+	batch   *leveldb.Batch
 
-	if binop.op.tokType == equalToken {
-		// TODO: rhs and lhs different types
-		if rhs.ty != lhs.ty {
-			return value{}, errors.New("equaling for different types")
-		}
+	// keys tracks the keys written (true) and deleted (false) by the batch, the
+	// rest of the keys are looked up in the database.
+	keys map[string]bool
+}
 
-		switch lhs.ty {
-		case nullVal:
-			return value{ty: boolVal, boolVal: true}, nil
-		case boolVal:
-			return value{ty: boolVal, boolVal: lhs.boolVal == rhs.boolVal}, nil
-		case stringVal:
-			return value{ty: boolVal, boolVal: lhs.stringVal == rhs.stringVal}, nil
-		case integerVal:
-			return value{ty: boolVal, boolVal: lhs.integerVal == rhs.integerVal}, nil
-		}
+func (s *leveldbStorage) newBatch() storageBatch {
+	return &leveldbBatch{
+		storage: s,
+		batch:   new(leveldb.Batch),
+		keys:    make(map[string]bool),
 	}
+}
 
-	if binop.op.tokType == ltToken {
-		return value{ty: boolVal, boolVal: lhs.asInt() < rhs.asInt()}, nil
+func (b *leveldbBatch) exists(key []byte) (bool, error) {
+	if written, ok := b.keys[string(key)]; ok {
+		return written, nil
 	}
-
-	return value{ty: nullVal}, nil
+	return b.storage.kv.Has(key, nil)
 }
 
-func (e *exec) executeExpression(expr node, row *row) (value, error) {
-	switch parsedNode := expr.(type) {
-	case *literalNode:
-		return e.executeLiteral(parsedNode, row)
-	case *binopNode:
-		return e.executeBinop(parsedNode, row)
-	case *functionCallNode:
-		return e.executeFunctionCall(parsedNode, row)
-	}
+func (b *leveldbBatch) put(key, val []byte) {
+	b.keys[string(key)] = true
+	b.batch.Put(key, val)
+}
 
-	return value{}, nil
+func (b *leveldbBatch) delete(key []byte) {
+	b.keys[string(key)] = false
+	b.batch.Delete(key)
 }
 
-func (e *exec) executeLiteral(l *literalNode, row *row) (value, error) {
-	litToken := l.lit
-	switch litToken.tokType {
-	case integerToken:
-		convertedNum, err := strconv.Atoi(litToken.content)
+// putRow writes a row under row.key together with its index entries. A row of a
+// table with a primary key fails if another row has the same key.
+func (b *leveldbBatch) putRow(table string, row *row) error {
+	if len(row.table.PrimaryKey) > 0 {
+		exists, err := b.exists(row.key)
 		if err != nil {
-			return value{}, nil
+			return err
 		}
-		return value{ty: integerVal, integerVal: int64(convertedNum)}, nil
-	case stringToken:
-		return value{ty: stringVal, stringVal: litToken.content}, nil
-	case identifierToken:
-		return row.Get(litToken.content), nil
-	default:
-		return value{}, nil
-	}
-}
 
-func (e *exec) executeFunctionCall(fcn *functionCallNode, row *row) (value, error) {
-	fn, ok := builtinFuncs[strings.ToLower(fcn.name.content)]
-	if !ok {
-		return value{}, fmt.Errorf("function was not found: %s", fcn.name.content)
+		if exists {
+			return fmt.Errorf("duplicate primary key %s in table %s", valuesString(row, row.table.primaryKey()), table)
+		}
 	}
 
-	return fn(e, row, fcn.args)
+	b.put(row.key, encodeRow(row))
+	for i := range row.table.Indexes {
+		if err := b.indexRow(table, &row.table.Indexes[i], row); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (e *exec) executeSelect(sn *selectNode) (*QueryResponse, error) {
-	_, err := e.storage.getTable(sn.from.content)
+// insertRow stores the row under its primary key or a new random key.
+func (b *leveldbBatch) insertRow(table string, row *row) error {
+	key, err := primaryKeyOf(row.table, row)
 	if err != nil {
-		return nil, fmt.Errorf("cannot get table: %s", err)
+		return err
 	}
 
-	requestedFields := make([]string, 0, len(sn.columns))
-	for _, col := range sn.columns {
-		lit, ok := col.(*literalNode)
-		if !ok {
-			continue
-		}
-
-		if lit.lit.tokType == identifierToken {
-			requestedFields = append(requestedFields, lit.lit.content)
-		}
+	if key == nil {
+		key = newRowKey(row.table)
 	}
 
-	resp := &QueryResponse{
-		fields: requestedFields,
-		empty:  false,
-	}
+	row.key = append(row.key[:0], key...)
+	return b.putRow(table, row)
+}
 
-	iter, err := e.storage.getRowIterator(sn.from.content)
+// updateRow replaces the old version of a row with the updated one. A row whose
+// primary key changed moves to its new key.
+func (b *leveldbBatch) updateRow(table string, old, updated *row) error {
+	key, err := primaryKeyOf(updated.table, updated)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't get row iterator")
+		return err
 	}
-	defer iter.Close()
 
-	row, ok := iter.Next()
-	for ok {
-		add := false
-		if sn.where != nil {
-			val, err := e.executeExpression(sn.where, row)
-			if err != nil {
-				row.Release()
-				return nil, fmt.Errorf("something went wrong when executing where: %s", err)
-			}
+	if key == nil {
+		key = old.key
+	} else if bytes.Equal(key, old.key) && b.keys[string(key)] {
+		// another row of this batch moved to the key of this row
+		return fmt.Errorf("duplicate primary key %s in table %s", valuesString(updated, updated.table.primaryKey()), table)
+	}
 
-			add = val.asBool()
-		} else {
-			add = true
-		}
+	// the old key and index entries are free for other rows unless this row
+	// writes them again
+	b.deleteRow(table, old)
 
-		if add {
-			var rowRes []string
-			for _, col := range sn.columns {
-				val, err := e.executeExpression(col, row)
-				if err != nil {
-					row.Release()
-					return nil, fmt.Errorf("error executing expression: %s", err)
-				}
+	updated.key = append(updated.key[:0], key...)
+	return b.putRow(table, updated)
+}
 
-				rowRes = append(rowRes, val.asStr())
-			}
+// deleteRow removes the row stored under row.key and its index entries.
+func (b *leveldbBatch) deleteRow(table string, row *row) {
+	b.delete(row.key)
+	for i := range row.table.Indexes {
+		key, _, _ := indexEntry(&row.table.Indexes[i], row)
+		b.delete(key)
+	}
+}
 
-			resp.rows = append(resp.rows, rowRes)
+// indexRow adds the entry of a row to an index, it fails if a unique index
+// already has an entry with the same values.
+func (b *leveldbBatch) indexRow(table string, ix *index, row *row) error {
+	key, unique, val := indexEntry(ix, row)
+	if unique {
+		exists, err := b.exists(key)
+		if err != nil {
+			return err
 		}
 
-		row.Release()
-		row, ok = iter.Next()
+		if exists {
+			return fmt.Errorf("duplicate key %s in unique index %s", valuesString(row, row.table.positions(ix.Columns)), ix.Name)
+		}
 	}
 
-	return resp, nil
+	b.put(key, val)
+	return nil
 }
 
-func (e *exec) executeCreateTable(cn *createTableNode) (*QueryResponse, error) {
-	cols := make([]string, 0, len(cn.columns))
-	types := make([]string, 0, len(cn.columns))
+// writeTable stores the catalog entry of the table as part of the batch.
+func (b *leveldbBatch) writeTable(t *table) {
+	b.batch.Put(catalogKey(t.Name), encodeTable(t))
+}
 
-	for _, col := range cn.columns {
-		cols = append(cols, col.name.content)
-		types = append(types, col.kind.content)
-	}
+func (b *leveldbBatch) commit() error {
+	return b.storage.kv.Write(b.batch, nil)
+}
 
-	table := &table{
-		Name:    cn.table.content,
-		Types:   types,
-		Columns: cols,
+func (ri *leveldbRowIterator) Next() (*row, bool) {
+	if !ri.iter.Next() {
+		return nil, false
 	}
+	row := newRow(ri.table)
+	row.key = append(row.key, ri.iter.Key()...)
+	decodeRow(row, ri.iter.Value())
 
-	err := e.storage.writeTable(table)
+	return row, true
+}
+
+func (ri *leveldbRowIterator) Close() error {
+	err := ri.iter.Error()
+	ri.iter.Release()
+	return err
+}
+
+func (s *leveldbStorage) getRowIterator(table string) (storageIterator, error) {
+	tableInfo, err := s.getTable(table)
 	if err != nil {
 		return nil, err
 	}
 
-	return &QueryResponse{empty: true}, nil
+	r := util.BytesPrefix(rowPrefix(tableInfo))
+	return &leveldbRowIterator{
+		table: tableInfo,
+		iter:  s.kv.NewIterator(r, nil),
+	}, nil
 }
 
-func (e *exec) executeInsert(in *insertNode) (*QueryResponse, error) {
-	emptyRow := &row{}
-	resRow := &row{}
-	for _, val := range in.values {
-		expr, err := e.executeExpression(val, emptyRow)
-		if err != nil {
-			return nil, err
-		}
+// readAt returns what the rows as of ts are read from. Versions older than the
+// last commit don't change, so the past is read straight from the database and
+// doesn't see the writes of a transaction.
+func (s *leveldbStorage) readAt(ts uint64) kvReader {
+	if ts == latestTimestamp {
+		return s.kv
+	}
+	return &versionedReader{r: s.db, ts: ts}
+}
 
-		resRow.Append(expr)
+func (s *leveldbStorage) getRowRange(table string, start, limit []byte, ts uint64) (storageIterator, error) {
+	tableInfo, err := s.getTable(table)
+	if err != nil {
+		return nil, err
 	}
 
-	err := e.storage.writeRow(in.table.content, resRow)
+	return &leveldbRowIterator{
+		table: tableInfo,
+		iter:  s.readAt(ts).NewIterator(&util.Range{Start: start, Limit: limit}, nil),
+	}, nil
+}
+
+func (s *leveldbStorage) getRow(table string, key []byte, ts uint64) (*row, error) {
+	tableInfo, err := s.getTable(table)
 	if err != nil {
 		return nil, err
 	}
 
-	return &QueryResponse{empty: true}, nil
+	value, err := s.readAt(ts).Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	row := newRow(tableInfo)
+	row.key = append(row.key, key...)
+	decodeRow(row, value)
+	return row, nil
+}
+
+type leveldbIndexIterator struct {
+	storage  *leveldbStorage
+	table    *table
+	index    *index
+	prefix   []byte // the row key without the part from the index entry
+	covering bool
+	iter     iterator.Iterator
+	err      error
+}
+
+func (s *leveldbStorage) getIndexRange(table string, ix *index, start, limit []byte, covering bool) (storageIterator, error) {
+	tableInfo, err := s.getTable(table)
+	if err != nil {
+		return nil, err
+	}
+
+	return &leveldbIndexIterator{
+		storage:  s,
+		table:    tableInfo,
+		index:    ix,
+		prefix:   rowPrefix(tableInfo),
+		covering: covering,
+		iter:     s.kv.NewIterator(&util.Range{Start: start, Limit: limit}, nil),
+	}, nil
+}
+
+// indexColumns returns the ids of the columns whose values an entry of the index
+// holds, in the order they are stored.
+func indexColumns(ix *index) []int {
+	return append(append([]int(nil), ix.Columns...), ix.Include...)
+}
+
+func (ii *leveldbIndexIterator) Next() (*row, bool) {
+	if ii.err != nil || !ii.iter.Next() {
+		return nil, false
+	}
+
+	r := &byteReader{buf: ii.iter.Value()}
+	key := append(ii.prefix[:len(ii.prefix):len(ii.prefix)], r.bytes()...)
+
+	row := newRow(ii.table)
+	row.key = append(row.key, key...)
+	if !ii.covering {
+		value, err := ii.storage.kv.Get(key, nil)
+		if err != nil {
+			row.Release()
+			ii.err = err
+			return nil, false
+		}
+
+		decodeRow(row, value)
+		return row, true
+	}
+
+	for range ii.table.Columns {
+		row.Append(value{ty: nullVal})
+	}
+	for _, idx := range ii.table.positions(indexColumns(ii.index)) {
+		row.Cells[idx] = deserializeValue(r.bytes())
+	}
+
+	if r.err != nil {
+		row.Release()
+		ii.err = r.err
+		return nil, false
+	}
+	return row, true
+}
+
+func (ii *leveldbIndexIterator) Close() error {
+	err := ii.err
+	if iterErr := ii.iter.Error(); err == nil {
+		err = iterErr
+	}
+	ii.iter.Release()
+	return err
+}
+
+func (s *leveldbStorage) writeTable(table *table) error {
+	key := catalogKey(table.Name)
+	return s.kv.Put(key, encodeTable(table), nil)
+}
+
+func (s *leveldbStorage) getTable(name string) (*table, error) {
+	key := catalogKey(name)
+	value, err := s.kv.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, errNoSuchTable
+	} else if err != nil {
+		return nil, err
+	}
+
+	return decodeTable(name, value)
+}
+
+// renameTable moves the catalog entry of the table to the new name. Rows and
+// index entries are stored under the key of the table, which doesn't change.
+func (s *leveldbStorage) renameTable(oldName, newName string) error {
+	table, err := s.getTable(oldName)
+	if err != nil {
+		return err
+	}
+
+	table.Name = newName
+	batch := new(leveldb.Batch)
+	batch.Delete(catalogKey(oldName))
+	batch.Put(catalogKey(newName), encodeTable(table))
+
+	return s.kv.Write(batch, nil)
+}
+
+// deleteRange removes every key starting with prefix. The keys are streamed
+// from an iterator and deleted in fixed size batches, so the range is never
+// loaded into memory at once.
+func deleteRange(kv kvStore, prefix []byte) error {
+	iter := kv.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+		if batch.Len() >= deleteBatchSize {
+			if err := kv.Write(batch, nil); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	return kv.Write(batch, nil)
+}
+
+func (s *leveldbStorage) truncateTable(name string) error {
+	tbl, err := s.getTable(name)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteRange(s.kv, rowPrefix(tbl)); err != nil {
+		return err
+	}
+	return deleteRange(s.kv, tablePrefix("idx", tbl))
+}
+
+// findIndex returns the table that has the index with the given name.
+func (s *leveldbStorage) findIndex(name string) (*table, error) {
+	prefix := []byte("tbl_")
+	iter := s.kv.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		tableName := strings.TrimSuffix(string(iter.Key()[len(prefix):]), "_")
+		tbl, err := decodeTable(tableName, iter.Value())
+		if err != nil {
+			return nil, err
+		}
+
+		if tbl.index(name) != -1 {
+			return tbl, nil
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return nil, errNoSuchIndex
+}
+
+// dropIndex removes the index from the catalog before its entries, an index id
+// is never reused so entries left behind by a crash are never read.
+func (s *leveldbStorage) dropIndex(tbl *table, name string) error {
+	i := tbl.index(name)
+	if i == -1 {
+		return errNoSuchIndex
+	}
+
+	ix := tbl.Indexes[i]
+	tbl.Indexes = append(tbl.Indexes[:i:i], tbl.Indexes[i+1:]...)
+	if err := s.writeTable(tbl); err != nil {
+		return err
+	}
+
+	return deleteRange(s.kv, indexPrefix(tbl, ix.ID))
+}
+
+// dropTable removes the rows before the catalog entry, if the drop gets
+// interrupted the table still exists and the drop can just be retried.
+func (s *leveldbStorage) dropTable(name string) error {
+	if err := s.truncateTable(name); err != nil {
+		return err
+	}
+
+	return s.kv.Delete(catalogKey(name), nil)
+}
+
+func (s *leveldbStorage) tableSize(table string) (int64, error) {
+	tbl, err := s.getTable(table)
+	if err != nil {
+		return 0, err
+	}
+
+	r := util.BytesPrefix(rowPrefix(tbl))
+	sizes, err := s.db.SizeOf([]util.Range{*r})
+	if err != nil {
+		return 0, err
+	}
+	return sizes.Sum(), nil
+}
+
+// scratchPrefix is the start of the temporary keyspaces, every scratch space gets
+// its own random id after it.
+const scratchPrefix = "tmp_"
+
+// leveldbScratch writes straight to the database, also in a transaction, as the
+// keys are gone once the query finishes.
+type leveldbScratch struct {
+	storage *leveldbStorage
+	prefix  []byte
+	batch   *leveldb.Batch
+}
+
+func (s *leveldbStorage) newScratch() (scratchSpace, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &leveldbScratch{
+		storage: s,
+		prefix:  []byte(fmt.Sprintf("%s%x_", scratchPrefix, id)),
+		batch:   new(leveldb.Batch),
+	}, nil
+}
+
+func (sc *leveldbScratch) key(key []byte) []byte {
+	return append(sc.prefix[:len(sc.prefix):len(sc.prefix)], key...)
+}
+
+// put buffers the write, the buffer is written once it is big enough or when
+// the scratch space is read.
+func (sc *leveldbScratch) put(key, value []byte) error {
+	sc.batch.Put(sc.key(key), value)
+	if sc.batch.Len() >= deleteBatchSize {
+		return sc.flush()
+	}
+	return nil
+}
+
+func (sc *leveldbScratch) flush() error {
+	if sc.batch.Len() == 0 {
+		return nil
+	}
+
+	err := sc.storage.db.Write(sc.batch, nil)
+	sc.batch.Reset()
+	return err
+}
+
+func (sc *leveldbScratch) has(key []byte) (bool, error) {
+	if err := sc.flush(); err != nil {
+		return false, err
+	}
+	return sc.storage.db.Has(sc.key(key), nil)
+}
+
+func (sc *leveldbScratch) scan(prefix []byte) iterator.Iterator {
+	if err := sc.flush(); err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	return sc.storage.db.NewIterator(util.BytesPrefix(sc.key(prefix)), nil)
+}
+
+func (sc *leveldbScratch) Close() error {
+	sc.batch.Reset()
+	return deleteRange(sc.storage.db, sc.prefix)
+}
+
+// defaultMemoryBudget is the amount of memory in bytes that a single operator
+// like DISTINCT can use before it moves its state to a scratch space.
+const defaultMemoryBudget = 64 << 20
+
+// defaultGCTTL is how long the old versions of rows are kept around for AS OF
+// SYSTEM TIME queries.
+const defaultGCTTL = 25 * time.Hour
+
+type exec struct {
+	storage      storage
+	memoryBudget int
+	gcTTL        time.Duration
+}
+
+func (e *exec) ttl() time.Duration {
+	if e.gcTTL <= 0 {
+		return defaultGCTTL
+	}
+	return e.gcTTL
+}
+
+func (e *exec) budget() int {
+	if e.memoryBudget <= 0 {
+		return defaultMemoryBudget
+	}
+	return e.memoryBudget
+}
+
+type QueryResponse struct {
+	fields []string
+	rows   [][]string
+	empty  bool
+
+	// dml is set for statements that modify rows, for those String reports the
+	// amount of affected rows instead of "ok".
+	dml      bool
+	affected int
+}
+
+// RowsAffected returns the amount of rows changed by a modifying statement.
+func (qr *QueryResponse) RowsAffected() int {
+	return qr.affected
+}
+
+func (qr *QueryResponse) String() string {
+	if qr.empty {
+		if qr.dml {
+			if qr.affected == 1 {
+				return "1 row affected"
+			}
+			return fmt.Sprintf("%d rows affected", qr.affected)
+		}
+		return "ok"
+	}
+
+	var b strings.Builder
+	b.WriteString("| ")
+
+	for _, field := range qr.fields {
+		b.WriteString(field)
+		b.WriteString("\t\t|")
+	}
+	b.WriteByte('\n')
+
+	b.WriteString("+ ")
+	for _, field := range qr.fields {
+		b.WriteString(strings.Repeat("=", len(field)) + "\t\t+")
+	}
+	b.WriteByte('\n')
+
+	for _, row := range qr.rows {
+		b.WriteString("| ")
+		for _, cell := range row {
+			b.WriteString(cell)
+			b.WriteString("\t\t|")
+		}
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+func (e *exec) executeBinop(binop *binopNode, row *row) (value, error) {
+	lhs, err := e.executeExpression(binop.left, row)
+	if err != nil {
+		return value{}, err
+	}
+
+	if binop.op.tokType == andToken || binop.op.tokType == orToken {
+		return e.executeLogical(binop, lhs, row)
+	}
+
+	rhs, err := e.executeExpression(binop.right, row)
+	if err != nil {
+		return value{}, err
+	}
+
+	switch binop.op.tokType {
+	case equalToken, neqToken, ltToken, leToken, gtToken, geToken:
+		if lhs.ty == nullVal || rhs.ty == nullVal {
+			return value{ty: nullVal}, nil
+		}
+		return compareBinop(binop.op.tokType, lhs, rhs), nil
+	case isToken, isNotDistinctFromToken:
+		return value{ty: boolVal, boolVal: compareValues(lhs, rhs) == 0}, nil
+	case isNotToken, isDistinctFromToken:
+		return value{ty: boolVal, boolVal: compareValues(lhs, rhs) != 0}, nil
+	case plusToken, minusToken, starToken, slashToken, percentToken:
+		return arithmetic(binop.op, lhs, rhs)
+	case concattoken:
+		if lhs.ty == nullVal || rhs.ty == nullVal {
+			return value{ty: nullVal}, nil
+		}
+		return value{ty: stringVal, stringVal: lhs.asStr() + rhs.asStr()}, nil
+	}
+
+	return value{}, fmt.Errorf("unsupported operator: %s", binop.op.content)
+}
+
+// compareBinop compares values with the typed order of compareValues, values of
+// different types are never equal. Comparisons with NULL are handled by the
+// caller, they are unknown.
+func compareBinop(op int, lhs, rhs value) value {
+	c := compareValues(lhs, rhs)
+
+	var res bool
+	switch op {
+	case equalToken:
+		res = c == 0
+	case neqToken:
+		res = c != 0
+	case ltToken:
+		res = c < 0
+	case leToken:
+		res = c <= 0
+	case gtToken:
+		res = c > 0
+	case geToken:
+		res = c >= 0
+	}
+	return value{ty: boolVal, boolVal: res}
+}
+
+func arithmetic(op token, lhs, rhs value) (value, error) {
+	if lhs.ty == nullVal || rhs.ty == nullVal {
+		return value{ty: nullVal}, nil
+	}
+
+	if lhs.ty != integerVal || rhs.ty != integerVal {
+		return value{}, fmt.Errorf("operator %s expects integers", op.content)
+	}
+
+	a, b := lhs.integerVal, rhs.integerVal
+	var res int64
+	switch op.tokType {
+	case plusToken:
+		res = a + b
+	case minusToken:
+		res = a - b
+	case starToken:
+		res = a * b
+	case slashToken, percentToken:
+		if b == 0 {
+			return value{}, errors.New("division by zero")
+		}
+		if op.tokType == slashToken {
+			res = a / b
+		} else {
+			res = a % b
+		}
+	}
+	return value{ty: integerVal, integerVal: res}, nil
+}
+
+// truth maps a value to SQL's three-valued logic, the second result is false
+// when the value is unknown.
+func truth(v value) (bool, bool) {
+	if v.ty == nullVal {
+		return false, false
+	}
+	return v.asBool(), true
+}
+
+func boolValue(b, known bool) value {
+	if !known {
+		return value{ty: nullVal}
+	}
+	return value{ty: boolVal, boolVal: b}
+}
+
+// executeLogical evaluates AND and OR. The right side is skipped when the left
+// side already decides the result, otherwise unknown is only returned if the
+// right side doesn't decide it either.
+func (e *exec) executeLogical(binop *binopNode, lhs value, row *row) (value, error) {
+	isOr := binop.op.tokType == orToken
+
+	l, lknown := truth(lhs)
+	if lknown && l == isOr {
+		return boolValue(isOr, true), nil
+	}
+
+	rhs, err := e.executeExpression(binop.right, row)
+	if err != nil {
+		return value{}, err
+	}
+
+	r, rknown := truth(rhs)
+	if rknown && r == isOr {
+		return boolValue(isOr, true), nil
+	}
+
+	return boolValue(!isOr, lknown && rknown), nil
+}
+
+func (e *exec) executeUnary(un *unaryNode, row *row) (value, error) {
+	operand, err := e.executeExpression(un.operand, row)
+	if err != nil {
+		return value{}, err
+	}
+
+	if un.op.tokType == notToken {
+		b, known := truth(operand)
+		return boolValue(!b, known), nil
+	}
+
+	switch operand.ty {
+	case nullVal:
+		return operand, nil
+	case integerVal:
+		return value{ty: integerVal, integerVal: -operand.integerVal}, nil
+	}
+	return value{}, fmt.Errorf("operator %s expects an integer", un.op.content)
+}
+
+func (e *exec) executeExpression(expr node, row *row) (value, error) {
+	switch parsedNode := expr.(type) {
+	case *literalNode:
+		return e.executeLiteral(parsedNode, row)
+	case *binopNode:
+		return e.executeBinop(parsedNode, row)
+	case *unaryNode:
+		return e.executeUnary(parsedNode, row)
+	case *inNode:
+		return e.executeIn(parsedNode, row)
+	case *betweenNode:
+		return e.executeBetween(parsedNode, row)
+	case *likeNode:
+		return e.executeLike(parsedNode, row)
+	case *caseNode:
+		return e.executeCase(parsedNode, row)
+	case *aliasNode:
+		return e.executeExpression(parsedNode.expr, row)
+	case *functionCallNode:
+		return e.executeFunctionCall(parsedNode, row)
+	}
+
+	return value{}, nil
+}
+
+func (e *exec) executeLiteral(l *literalNode, row *row) (value, error) {
+	litToken := l.lit
+	switch litToken.tokType {
+	case integerToken:
+		convertedNum, err := strconv.Atoi(litToken.content)
+		if err != nil {
+			return value{}, nil
+		}
+		return value{ty: integerVal, integerVal: int64(convertedNum)}, nil
+	case stringToken:
+		return value{ty: stringVal, stringVal: litToken.content}, nil
+	case nullToken:
+		return value{ty: nullVal}, nil
+	case starToken:
+		return value{}, errors.New("* is only allowed in the select list and in count(*)")
+	case identifierToken:
+		if row.table != nil {
+			if _, err := row.table.resolveColumn(litToken.content); err != nil {
+				return value{}, err
+			}
+		}
+		return row.Get(litToken.content), nil
+	default:
+		return value{}, nil
+	}
+}
+
+func (e *exec) executeFunctionCall(fcn *functionCallNode, row *row) (value, error) {
+	if isAggregate(fcn) {
+		val, ok := row.aggregates[fcn]
+		if !ok {
+			return value{}, fmt.Errorf("aggregate function %s is not allowed here", fcn.name.content)
+		}
+		return val, nil
+	}
+
+	fn, ok := builtinFuncs[strings.ToLower(fcn.name.content)]
+	if !ok {
+		return value{}, fmt.Errorf("function was not found: %s", fcn.name.content)
+	}
+
+	return fn(e, row, fcn.args)
+}
+
+// selectResult is a typed result row of a select along with its ORDER BY keys.
+type selectResult struct {
+	cells    []value
+	sortKeys []value
+	seq      int
+}
+
+// evalCount evaluates a LIMIT or OFFSET expression, null means that the clause
+// wasn't given.
+func (e *exec) evalCount(n node, clause string) (int, error) {
+	if n == nil {
+		return -1, nil
+	}
+
+	val, err := e.executeExpression(n, &row{})
+	if err != nil {
+		return 0, err
+	}
+
+	if val.ty != integerVal || val.integerVal < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", clause)
+	}
+
+	return int(val.integerVal), nil
+}
+
+// asOfTimeLayouts are the layouts of the timestamps AS OF SYSTEM TIME takes.
+var asOfTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999"}
+
+// asOfTimestamp evaluates the time of an AS OF SYSTEM TIME clause. It is either
+// a timestamp, a negative duration from now like '-10s' or the nanoseconds since
+// the epoch. Versions older than the GC TTL may be gone, so reading them fails.
+func (e *exec) asOfTimestamp(n node) (uint64, error) {
+	val, err := e.executeExpression(n, &row{})
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var at time.Time
+	switch val.ty {
+	case integerVal:
+		at = time.Unix(0, val.integerVal)
+	case stringVal:
+		if d, err := time.ParseDuration(val.stringVal); err == nil {
+			at = now.Add(d)
+		} else if nanos, err := strconv.ParseInt(val.stringVal, 10, 64); err == nil {
+			at = time.Unix(0, nanos)
+		} else {
+			for _, layout := range asOfTimeLayouts {
+				if at, err = time.Parse(layout, val.stringVal); err == nil {
+					break
+				}
+			}
+			if err != nil {
+				return 0, fmt.Errorf("invalid AS OF SYSTEM TIME: %s", val.stringVal)
+			}
+		}
+	default:
+		return 0, errors.New("AS OF SYSTEM TIME must be a timestamp or a duration")
+	}
+
+	if at.After(now) {
+		return 0, fmt.Errorf("AS OF SYSTEM TIME %s is in the future", at.Format(time.RFC3339Nano))
+	}
+	if at.Before(now.Add(-e.ttl())) {
+		return 0, fmt.Errorf("AS OF SYSTEM TIME %s is older than the GC TTL of %s", at.Format(time.RFC3339Nano), e.ttl())
+	}
+	return uint64(at.UnixNano()), nil
+}
+
+// selectOutput projects the rows that make it through the query and handles
+// ORDER BY, LIMIT and OFFSET for them.
+type selectOutput struct {
+	sn       *selectNode
+	ordinals []int
+	limit    int
+	offset   int
+
+	top      *topN
+	distinct *distinctSet // set for SELECT DISTINCT
+	results  []selectResult
+	seq      int
+
+	// emit receives the rows right away when they don't need to be sorted,
+	// instead of keeping them until finish.
+	emit    func([]value) error
+	emitted int
+}
+
+func (e *exec) newSelectOutput(sn *selectNode) (*selectOutput, error) {
+	out := &selectOutput{
+		sn:       sn,
+		ordinals: make([]int, len(sn.orderBy)),
+	}
+
+	var err error
+	for i, term := range sn.orderBy {
+		out.ordinals[i], err = columnOrdinal(term.expr, sn.columns)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out.limit, err = e.evalCount(sn.limit, "LIMIT")
+	if err != nil {
+		return nil, err
+	}
+
+	out.offset, err = e.evalCount(sn.offset, "OFFSET")
+	if err != nil {
+		return nil, err
+	}
+	if out.offset == -1 {
+		out.offset = 0
+	}
+
+	// with an ORDER BY only the first offset+limit rows need to be kept, without
+	// one the scan can stop as soon as it has produced them.
+	if out.limit != -1 && len(sn.orderBy) > 0 {
+		out.top = &topN{terms: sn.orderBy, n: out.offset + out.limit}
+	}
+
+	if sn.distinct {
+		out.distinct = e.newDistinctSet()
+	}
+
+	return out, nil
+}
+
+func (out *selectOutput) Close() error {
+	if out.distinct != nil {
+		return out.distinct.Close()
+	}
+	return nil
+}
+
+// add evaluates the select list and sort keys for the row. It returns false once
+// no more rows are needed.
+func (out *selectOutput) add(e *exec, row *row) (bool, error) {
+	res := selectResult{cells: make([]value, 0, len(out.sn.columns)), seq: out.seq}
+	out.seq++
+	for _, col := range out.sn.columns {
+		val, err := e.executeExpression(col, row)
+		if err != nil {
+			return false, fmt.Errorf("error executing expression: %s", err)
+		}
+
+		res.cells = append(res.cells, val)
+	}
+
+	if out.distinct != nil {
+		isNew, err := out.distinct.add(valuesKey(res.cells))
+		if err != nil || !isNew {
+			return err == nil, err
+		}
+	}
+
+	// sort keys are evaluated against the source row, so the rows can be
+	// ordered by columns that are not selected.
+	for i, term := range out.sn.orderBy {
+		if out.ordinals[i] != -1 {
+			res.sortKeys = append(res.sortKeys, res.cells[out.ordinals[i]])
+			continue
+		}
+
+		val, err := e.executeExpression(term.expr, row)
+		if err != nil {
+			return false, fmt.Errorf("error executing order by: %s", err)
+		}
+		res.sortKeys = append(res.sortKeys, val)
+	}
+
+	if out.top != nil {
+		out.top.add(res)
+		return true, nil
+	}
+
+	if out.emit != nil && len(out.sn.orderBy) == 0 {
+		// LIMIT 0 is reached before the first row
+		if out.limit != -1 && out.emitted >= out.offset+out.limit {
+			return false, nil
+		}
+
+		out.emitted++
+		if out.emitted > out.offset {
+			if err := out.emit(res.cells); err != nil {
+				return false, err
+			}
+		}
+		return out.limit == -1 || out.emitted < out.offset+out.limit, nil
+	}
+
+	out.results = append(out.results, res)
+	return out.limit == -1 || len(out.results) < out.offset+out.limit, nil
+}
+
+func (out *selectOutput) finish() []selectResult {
+	results := out.results
+	if out.top != nil {
+		results = out.top.sorted()
+	} else if len(out.sn.orderBy) > 0 {
+		sortResults(out.sn.orderBy, results)
+	}
+
+	if out.offset >= len(results) {
+		return nil
+	}
+	results = results[out.offset:]
+
+	if out.limit != -1 && out.limit < len(results) {
+		results = results[:out.limit]
+	}
+	return results
+}
+
+// scanRows calls fn for every row of the source that matches the where clause
+// until fn returns false. The row is released after fn returns.
+func (e *exec) scanRows(src rowSource, where node, fn func(*row) (bool, error)) (err error) {
+	iter, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := iter.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	row, ok := iter.Next()
+	for ok {
+		add := true
+		if where != nil {
+			val, err := e.executeExpression(where, row)
+			if err != nil {
+				row.Release()
+				return fmt.Errorf("something went wrong when executing where: %s", err)
+			}
+
+			add = val.asBool()
+		}
+
+		more := true
+		if add {
+			more, err = fn(row)
+			if err != nil {
+				row.Release()
+				return err
+			}
+		}
+
+		row.Release()
+		if !more {
+			break
+		}
+		row, ok = iter.Next()
+	}
+
+	return nil
+}
+
+// planSelect plans the FROM clause and expands the stars of the select list,
+// which depends on the schema at the time of the query.
+func (e *exec) planSelect(sn *selectNode) (*selectNode, rowSource, error) {
+	var asOf uint64
+	if sn.asOf != nil {
+		var err error
+		if asOf, err = e.asOfTimestamp(sn.asOf); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	src, err := e.planFrom(sn.from, asOf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns, err := expandStars(sn.columns, src.schema())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ts, ok := src.(*tableScan); ok {
+		used := append([]node(nil), columns...)
+		used = append(used, sn.groupBy...)
+		used = append(used, sn.having)
+		for _, term := range sn.orderBy {
+			used = append(used, term.expr)
+		}
+		src = ts.restrict(e, sn.where, used)
+	}
+
+	// the parsed statement is left as it is
+	expanded := *sn
+	expanded.columns = columns
+	return &expanded, src, nil
+}
+
+// runSelect calls emit for every result row of a planned select in order. Rows
+// that don't need sorting are passed on while the input is scanned.
+func (e *exec) runSelect(sn *selectNode, src rowSource, emit func([]value) error) error {
+	out, err := e.newSelectOutput(sn)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	out.emit = emit
+
+	aggregates, err := findAggregates(sn)
+	if err != nil {
+		return err
+	}
+
+	if len(aggregates) > 0 || len(sn.groupBy) > 0 || sn.having != nil {
+		err = e.executeGrouped(sn, src, aggregates, out)
+	} else {
+		err = e.scanRows(src, sn.where, func(r *row) (bool, error) {
+			return out.add(e, r)
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, res := range out.finish() {
+		if err := emit(res.cells); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *exec) executeSelect(sn *selectNode) (*QueryResponse, error) {
+	sn, src, err := e.planSelect(sn)
+	if err != nil {
+		return nil, err
+	}
+
+	requestedFields := make([]string, 0, len(sn.columns))
+	for _, col := range sn.columns {
+		requestedFields = append(requestedFields, columnName(col))
+	}
+
+	resp := &QueryResponse{
+		fields: requestedFields,
+		empty:  false,
+	}
+
+	err = e.runSelect(sn, src, func(cells []value) error {
+		rowRes := make([]string, 0, len(cells))
+		for _, val := range cells {
+			rowRes = append(rowRes, val.asStr())
+		}
+
+		resp.rows = append(resp.rows, rowRes)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (e *exec) executeCreateTable(cn *createTableNode) (*QueryResponse, error) {
+	if _, err := e.storage.getTable(cn.table.content); err == nil {
+		return nil, fmt.Errorf("table already exists: %s", cn.table.content)
+	} else if !errors.Is(err, errNoSuchTable) {
+		return nil, err
+	}
+
+	cols := make([]string, 0, len(cn.columns))
+	types := make([]string, 0, len(cn.columns))
+
+	for _, col := range cn.columns {
+		cols = append(cols, col.name.content)
+		types = append(types, col.kind.content)
+	}
+
+	table := &table{
+		Name:    cn.table.content,
+		Types:   types,
+		Columns: cols,
+	}
+	table.initSchema()
+
+	for _, col := range cn.primaryKey {
+		idx := table.columnIndex(col.content)
+		if idx == -1 {
+			return nil, fmt.Errorf("no such primary key column: %s", col.content)
+		}
+
+		for _, id := range table.PrimaryKey {
+			if id == table.ColumnIDs[idx] {
+				return nil, fmt.Errorf("column in primary key more than once: %s", col.content)
+			}
+		}
+		table.PrimaryKey = append(table.PrimaryKey, table.ColumnIDs[idx])
+	}
+
+	err := e.storage.writeTable(table)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QueryResponse{empty: true}, nil
+}
+
+// columnIDs returns the ids of the named columns of an index, what names the
+// kind of columns in errors.
+func columnIDs(tbl *table, columns []token, what string, seen map[int]bool) ([]int, error) {
+	ids := make([]int, 0, len(columns))
+	for _, col := range columns {
+		idx := tbl.columnIndex(col.content)
+		if idx == -1 {
+			return nil, fmt.Errorf("no such %s column: %s", what, col.content)
+		}
+
+		if seen[idx] {
+			return nil, fmt.Errorf("column in index more than once: %s", col.content)
+		}
+		seen[idx] = true
+		ids = append(ids, tbl.ColumnIDs[idx])
+	}
+	return ids, nil
+}
+
+// executeCreateIndex adds the index to the catalog and fills it with the rows
+// already in the table. Both happen in one batch, so a unique index that the
+// rows violate is never created.
+func (e *exec) executeCreateIndex(cn *createIndexNode) (*QueryResponse, error) {
+	tbl, err := e.storage.getTable(cn.table.content)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get table: %s", err)
+	}
+
+	if _, err := e.storage.findIndex(cn.name.content); err == nil {
+		return nil, fmt.Errorf("index already exists: %s", cn.name.content)
+	} else if !errors.Is(err, errNoSuchIndex) {
+		return nil, err
+	}
+
+	seen := make(map[int]bool)
+	columns, err := columnIDs(tbl, cn.columns, "index", seen)
+	if err != nil {
+		return nil, err
+	}
+
+	include, err := columnIDs(tbl, cn.include, "included", seen)
+	if err != nil {
+		return nil, err
+	}
+
+	tbl.Indexes = append(tbl.Indexes, index{
+		Name:    cn.name.content,
+		ID:      tbl.NextIndexID,
+		Unique:  cn.unique,
+		Columns: columns,
+		Include: include,
+	})
+	tbl.NextIndexID++
+	ix := &tbl.Indexes[len(tbl.Indexes)-1]
+
+	batch := e.storage.newBatch()
+	src := &tableScan{storage: e.storage, name: tbl.Name, table: tbl}
+	err = e.scanRows(src, nil, func(r *row) (bool, error) {
+		return true, batch.indexRow(tbl.Name, ix, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	batch.writeTable(tbl)
+	if err := batch.commit(); err != nil {
+		return nil, err
+	}
+
+	return &QueryResponse{empty: true}, nil
+}
+
+func (e *exec) executeDropIndex(dn *dropIndexNode) (*QueryResponse, error) {
+	tbl, err := e.storage.findIndex(dn.name.content)
+	if err == nil {
+		err = e.storage.dropIndex(tbl, dn.name.content)
+	}
+
+	if errors.Is(err, errNoSuchIndex) && dn.ifExists {
+		return &QueryResponse{empty: true}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &QueryResponse{empty: true}, nil
+}
+
+// insertTargets returns the index of the table column that gets each value of
+// an inserted row. Without a column list the values fill the columns in order.
+func insertTargets(tbl *table, columns []token, count int) ([]int, error) {
+	if len(columns) == 0 {
+		if count > len(tbl.Columns) {
+			return nil, fmt.Errorf("table %s has %d columns but %d values were given", tbl.Name, len(tbl.Columns), count)
+		}
+
+		targets := make([]int, count)
+		for i := range targets {
+			targets[i] = i
+		}
+		return targets, nil
+	}
+
+	targets := make([]int, 0, len(columns))
+	seen := make(map[int]bool)
+	for _, col := range columns {
+		idx := tbl.columnIndex(col.content)
+		if idx == -1 {
+			return nil, fmt.Errorf("no such column: %s", col.content)
+		}
+		if seen[idx] {
+			return nil, fmt.Errorf("column given more than once: %s", col.content)
+		}
+
+		seen[idx] = true
+		targets = append(targets, idx)
+	}
+
+	if count != len(targets) {
+		return nil, fmt.Errorf("%d columns were listed but %d values were given", len(targets), count)
+	}
+	return targets, nil
+}
+
+// executeInsert writes all rows in a single batch, so either every row of the
+// statement is stored or none is. Columns without a value get their default.
+func (e *exec) executeInsert(in *insertNode) (*QueryResponse, error) {
+	tbl, err := e.storage.getTable(in.table.content)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get table: %s", err)
+	}
+
+	if in.query != nil {
+		return e.executeInsertSelect(in, tbl)
+	}
+
+	targets, err := insertTargets(tbl, in.columns, len(in.rows[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	batch := e.storage.newBatch()
+	emptyRow := &row{}
+	resRow := &row{table: tbl}
+	for _, values := range in.rows {
+		vals, err := executeArgs(e, emptyRow, values)
+		if err != nil {
+			return nil, err
+		}
+
+		resRow.Cells = append(resRow.Cells[:0], tbl.Defaults...)
+		for i, v := range vals {
+			resRow.Cells[targets[i]] = v
+		}
+
+		if err := batch.insertRow(in.table.content, resRow); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := batch.commit(); err != nil {
+		return nil, err
+	}
+
+	return &QueryResponse{empty: true, dml: true, affected: len(in.rows)}, nil
+}
+
+// insertBatchSize is the amount of rows an INSERT INTO ... SELECT writes per
+// batch, so copying a big table doesn't build one huge batch.
+const insertBatchSize = 1000
+
+// executeInsertSelect streams the rows of the select into the table. Rows are
+// added to the writes of the statement in batches of insertBatchSize, which
+// only reach the database or the transaction once the statement succeeds. A
+// table scan reads the table as it was when the scan was opened, so INSERT INTO
+// t SELECT ... FROM t doesn't read its own rows back.
+func (e *exec) executeInsertSelect(in *insertNode, tbl *table) (*QueryResponse, error) {
+	sn, src, err := e.planSelect(in.query)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := insertTargets(tbl, in.columns, len(sn.columns))
+	if err != nil {
+		return nil, err
+	}
+
+	batch := e.storage.newBatch()
+	resRow := &row{table: tbl}
+	pending, affected := 0, 0
+	err = e.runSelect(sn, src, func(cells []value) error {
+		resRow.Cells = append(resRow.Cells[:0], tbl.Defaults...)
+		for i, v := range cells {
+			resRow.Cells[targets[i]] = v
+		}
+
+		if err := batch.insertRow(in.table.content, resRow); err != nil {
+			return err
+		}
+		affected++
+		if pending++; pending < insertBatchSize {
+			return nil
+		}
+
+		pending = 0
+		if err := batch.commit(); err != nil {
+			return err
+		}
+		batch = e.storage.newBatch()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if pending > 0 {
+		if err := batch.commit(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &QueryResponse{empty: true, dml: true, affected: affected}, nil
+}
+
+func (e *exec) executeUpdate(un *updateNode) (*QueryResponse, error) {
+	ts, err := e.planTableScan(un.table.content, un.where)
+	if err != nil {
+		return nil, err
+	}
+	tbl := ts.schema()
+
+	colIndexes := make([]int, len(un.assignments))
+	for i, a := range un.assignments {
+		colIndexes[i] = tbl.columnIndex(a.column.content)
+		if colIndexes[i] == -1 {
+			return nil, fmt.Errorf("no such column: %s", a.column.content)
+		}
+	}
+
+	iter, err := ts.open()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	batch := e.storage.newBatch()
+	updated := newRow(tbl)
+	defer updated.Release()
+
+	affected := 0
+	row, ok := iter.Next()
+	for ok {
+		match := true
+		if un.where != nil {
+			val, err := e.executeExpression(un.where, row)
+			if err != nil {
+				row.Release()
+				return nil, fmt.Errorf("something went wrong when executing where: %s", err)
+			}
+
+			match = val.asBool()
+		}
+
+		if match {
+			// every assignment is evaluated against the old row, so that
+			// SET a = b, b = a swaps the values.
+			updated.reset(tbl)
+			updated.Cells = append(updated.Cells, row.Cells...)
+			updated.key = append(updated.key[:0], row.key...)
+			for len(updated.Cells) < len(tbl.Columns) {
+				updated.Append(value{ty: nullVal})
+			}
+			for i, a := range un.assignments {
+				val, err := e.executeExpression(a.value, row)
+				if err != nil {
+					row.Release()
+					return nil, fmt.Errorf("error executing expression: %s", err)
+				}
+
+				updated.Cells[colIndexes[i]] = val
+			}
+
+			if err := batch.updateRow(un.table.content, row, updated); err != nil {
+				row.Release()
+				return nil, err
+			}
+			affected++
+		}
+
+		row.Release()
+		row, ok = iter.Next()
+	}
+
+	if err := batch.commit(); err != nil {
+		return nil, err
+	}
+
+	return &QueryResponse{empty: true, dml: true, affected: affected}, nil
+}
+
+func (e *exec) executeDelete(dn *deleteNode) (*QueryResponse, error) {
+	ts, err := e.planTableScan(dn.table.content, dn.where)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := ts.open()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	// all of the deletes go into a single batch so that a crash can never
+	// leave the statement half applied.
+	batch := e.storage.newBatch()
+	affected := 0
+	row, ok := iter.Next()
+	for ok {
+		match := true
+		if dn.where != nil {
+			val, err := e.executeExpression(dn.where, row)
+			if err != nil {
+				row.Release()
+				return nil, fmt.Errorf("something went wrong when executing where: %s", err)
+			}
+
+			match = val.asBool()
+		}
+
+		if match {
+			batch.deleteRow(dn.table.content, row)
+			affected++
+		}
+
+		row.Release()
+		row, ok = iter.Next()
+	}
+
+	if err := batch.commit(); err != nil {
+		return nil, err
+	}
+
+	return &QueryResponse{empty: true, dml: true, affected: affected}, nil
+}
+
+func (e *exec) executeDropTable(dn *dropTableNode) (*QueryResponse, error) {
+	err := e.storage.dropTable(dn.table.content)
+	if errors.Is(err, errNoSuchTable) && dn.ifExists {
+		return &QueryResponse{empty: true}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &QueryResponse{empty: true}, nil
+}
+
+func (e *exec) executeTruncateTable(tn *truncateTableNode) (*QueryResponse, error) {
+	if err := e.storage.truncateTable(tn.table.content); err != nil {
+		return nil, err
+	}
+
+	return &QueryResponse{empty: true}, nil
+}
+
+func (e *exec) executeAlterTable(an *alterTableNode) (*QueryResponse, error) {
+	tbl, err := e.storage.getTable(an.table.content)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get table: %s", err)
+	}
+
+	switch an.action {
+	case addColumnAction:
+		def := value{ty: nullVal}
+		if an.def != nil {
+			def, err = e.executeExpression(an.def, &row{})
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = tbl.addColumn(an.column.content, an.kind.content, def)
+	case dropColumnAction:
+		err = tbl.dropColumn(an.column.content)
+	case renameColumnAction:
+		err = tbl.renameColumn(an.column.content, an.newName.content)
+	case renameTableAction:
+		if _, err := e.storage.getTable(an.newName.content); err == nil {
+			return nil, fmt.Errorf("table already exists: %s", an.newName.content)
+		}
+
+		if err := e.storage.renameTable(tbl.Name, an.newName.content); err != nil {
+			return nil, err
+		}
+		return &QueryResponse{empty: true}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.storage.writeTable(tbl); err != nil {
+		return nil, err
+	}
+
+	return &QueryResponse{empty: true}, nil
+}
+
+// executeValues returns the rows of a VALUES statement.
+func (e *exec) executeValues(vn *valuesNode) (*QueryResponse, error) {
+	vs, err := e.planValues(vn)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &QueryResponse{fields: vs.table.Columns}
+	for _, vals := range vs.rows {
+		rowRes := make([]string, 0, len(vals))
+		for _, val := range vals {
+			rowRes = append(rowRes, val.asStr())
+		}
+
+		resp.rows = append(resp.rows, rowRes)
+	}
+
+	return resp, nil
 }
 
 func (e *exec) execute(n node) (*QueryResponse, error) {
 	switch astNode := n.(type) {
+	case *valuesNode:
+		return e.executeValues(astNode)
 	case *insertNode:
 		return e.executeInsert(astNode)
 	case *createTableNode:
 		return e.executeCreateTable(astNode)
+	case *createIndexNode:
+		return e.executeCreateIndex(astNode)
+	case *dropIndexNode:
+		return e.executeDropIndex(astNode)
 	case *selectNode:
 		return e.executeSelect(astNode)
+	case *updateNode:
+		return e.executeUpdate(astNode)
+	case *deleteNode:
+		return e.executeDelete(astNode)
+	case *dropTableNode:
+		return e.executeDropTable(astNode)
+	case *truncateTableNode:
+		return e.executeTruncateTable(astNode)
+	case *alterTableNode:
+		return e.executeAlterTable(astNode)
 	default:
 		return nil, errors.New("executing a non-parent node")
 	}
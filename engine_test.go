@@ -1,10 +1,15 @@
 package levelsql
 
 import (
+	"bytes"
 	"fmt"
+	"math/rand"
 	"os"
 	"reflect"
 	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 func TestStorage(t *testing.T) {
@@ -165,6 +170,933 @@ func TestStorage(t *testing.T) {
 	})
 }
 
+func TestSchemaVersions(t *testing.T) {
+	// catalog entry in the original format: (id integer, name string)
+	var legacy []byte
+	for _, s := range []string{"id", "integer", "name", "string"} {
+		legacy = appendBytes(legacy, []byte(s))
+	}
+
+	tbl, err := decodeTable("users", legacy)
+	if err != nil {
+		t.Fatalf("Failed to decode legacy table: %v", err)
+	}
+
+	if !reflect.DeepEqual(tbl.Columns, []string{"id", "name"}) || !reflect.DeepEqual(tbl.Types, []string{"integer", "string"}) {
+		t.Fatalf("Unexpected legacy table: %+v", tbl)
+	}
+
+	oldRow := &row{table: tbl, Cells: []value{
+		{ty: integerVal, integerVal: 1},
+		{ty: stringVal, stringVal: "Alice"},
+	}}
+	stored := encodeRow(oldRow)
+
+	if err := tbl.addColumn("age", "integer", value{ty: integerVal, integerVal: 18}); err != nil {
+		t.Fatalf("Failed to add column: %v", err)
+	}
+	if err := tbl.dropColumn("name"); err != nil {
+		t.Fatalf("Failed to drop column: %v", err)
+	}
+
+	tbl, err = decodeTable("users", encodeTable(tbl))
+	if err != nil {
+		t.Fatalf("Failed to decode table: %v", err)
+	}
+
+	decoded := &row{table: tbl}
+	decodeRow(decoded, stored)
+	want := []value{{ty: integerVal, integerVal: 1}, {ty: integerVal, integerVal: 18}}
+	if !reflect.DeepEqual(decoded.Cells, want) {
+		t.Fatalf("Expected %v, got %v", want, decoded.Cells)
+	}
+
+	newRow := &row{table: tbl, Cells: []value{
+		{ty: integerVal, integerVal: 2},
+		{ty: integerVal, integerVal: 40},
+	}}
+	decoded = &row{table: tbl}
+	decodeRow(decoded, encodeRow(newRow))
+	if !reflect.DeepEqual(decoded.Cells, newRow.Cells) {
+		t.Fatalf("Expected %v, got %v", newRow.Cells, decoded.Cells)
+	}
+}
+
+func TestKeyEncoding(t *testing.T) {
+	// in the order of compareValues
+	vals := []value{
+		{ty: nullVal},
+		{ty: boolVal, boolVal: false},
+		{ty: boolVal, boolVal: true},
+		{ty: integerVal, integerVal: -1 << 63},
+		{ty: integerVal, integerVal: -256},
+		{ty: integerVal, integerVal: -1},
+		{ty: integerVal, integerVal: 0},
+		{ty: integerVal, integerVal: 255},
+		{ty: integerVal, integerVal: 1<<63 - 1},
+		{ty: stringVal, stringVal: ""},
+		{ty: stringVal, stringVal: "a"},
+		{ty: stringVal, stringVal: "a\x00"},
+		{ty: stringVal, stringVal: "a\x00b"},
+		{ty: stringVal, stringVal: "a\x01"},
+		{ty: stringVal, stringVal: "ab"},
+		{ty: stringVal, stringVal: "b"},
+	}
+
+	for i := range vals {
+		for j := range vals {
+			want := compareValues(vals[i], vals[j])
+			if got := bytes.Compare(appendKeyValue(nil, vals[i]), appendKeyValue(nil, vals[j])); got != want {
+				t.Errorf("Comparing %v and %v: expected %d, got %d", vals[i], vals[j], want, got)
+			}
+		}
+	}
+
+	// a tuple is ordered by its first value before the second one
+	tbl := &table{Name: "t", Columns: []string{"s", "n"}, Types: []string{"string", "integer"}, PrimaryKey: []int{0, 1}}
+	tbl.initSchema()
+	key := func(s string, n int64) []byte {
+		t.Helper()
+		k, err := primaryKeyOf(tbl, &row{table: tbl, Cells: []value{{ty: stringVal, stringVal: s}, {ty: integerVal, integerVal: n}}})
+		if err != nil {
+			t.Fatalf("Failed to encode key: %v", err)
+		}
+		return k
+	}
+	if bytes.Compare(key("a", 9), key("ab", 1)) >= 0 {
+		t.Errorf("Expected (a, 9) to sort before (ab, 1)")
+	}
+
+	// the keys of one table never start with the prefix of another
+	tables := []*table{{Key: legacyTableKey("users")}, {Key: newTableKey()}, {Key: newTableKey()}}
+	for _, x := range tables {
+		for _, y := range tables {
+			if x != y && bytes.HasPrefix(rowPrefix(y), rowPrefix(x)) {
+				t.Errorf("The prefix of %q is the start of the prefix of %q", x.Key, y.Key)
+			}
+		}
+	}
+	if got := string(rowPrefix(tables[0])); got != "row_users_" {
+		t.Errorf("Expected the prefix of a table from before table keys to stay row_users_, got %s", got)
+	}
+}
+
+func TestPrimaryKeyScan(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE t (a INTEGER, b STRING, c INTEGER, PRIMARY KEY (a, b))",
+		"INSERT INTO t VALUES (1, 'x', 1), (1, 'y', 2), (2, 'x', 3), (3, 'z', 4), (4, 'x', 5)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	const (
+		full = iota
+		point
+		bounded
+	)
+
+	tests := []struct {
+		where string
+		scan  int
+		rows  int
+	}{
+		{"a = 1 AND b = 'y'", point, 1},
+		{"'x' = b AND c > 0 AND 2 = a", point, 1},
+		{"a = 1 AND b = 'q'", point, 0},
+		{"a = 1", bounded, 2},
+		{"a = 1 AND b > 'x'", bounded, 1},
+		{"a > 1 AND a <= 3", bounded, 2},
+		{"2 < a", bounded, 2},
+		{"a BETWEEN 2 AND 3", bounded, 2},
+		{"a < 0", bounded, 0},
+		{"a > 3 AND a < 2", bounded, 0},
+		{"b = 'x'", full, 3},
+		{"a = 1 OR a = 2", full, 3},
+		{"a = c", full, 1},
+		{"a = NULL", full, 0},
+	}
+
+	for _, tt := range tests {
+		query := "SELECT c FROM t WHERE " + tt.where
+		l := lexer{content: query}
+		p := parser{tokens: l.lex()}
+		parsed, err := p.pselect()
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", query, err)
+		}
+
+		_, src, err := db.executor.planSelect(parsed.(*selectNode))
+		if err != nil {
+			t.Fatalf("Failed to plan %q: %v", query, err)
+		}
+
+		ts := src.(*tableScan)
+		scan := full
+		if ts.point != nil {
+			scan = point
+		} else if ts.start != nil {
+			scan = bounded
+		}
+		if scan != tt.scan {
+			t.Errorf("%q: expected scan kind %d, got %d", tt.where, tt.scan, scan)
+		}
+
+		result, err := db.Execute(query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", query, err)
+		}
+		if len(result.rows) != tt.rows {
+			t.Errorf("%q: expected %d rows, got %v", tt.where, tt.rows, result.rows)
+		}
+	}
+}
+
+func TestIndexScan(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE t (a INTEGER PRIMARY KEY, b STRING, c INTEGER, d INTEGER)",
+		"INSERT INTO t VALUES (1, 'x', 1, 10), (2, 'y', 2, 20), (3, 'x', 3, 30), (4, 'z', 1, 40), (5, 12, 5, 50)",
+		"CREATE INDEX tb ON t (b) INCLUDE (c)",
+		"CREATE UNIQUE INDEX tcd ON t (c, d)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query    string
+		index    string // empty for a table scan
+		covering bool
+		rows     int
+	}{
+		{"SELECT c FROM t WHERE b = 'x'", "tb", true, 2},
+		{"SELECT a, c FROM t WHERE b = 'x'", "tb", false, 2},
+		{"SELECT COUNT(*) FROM t WHERE b > 'x'", "tb", true, 1},
+		{"SELECT b FROM t WHERE b = 'x' AND c = 3", "tb", true, 1},
+		{"SELECT b FROM t WHERE b = 'x' AND d = 30", "tb", false, 1},
+		{"SELECT b FROM t WHERE b = 'x' ORDER BY d", "tb", false, 2},
+		{"SELECT d FROM t WHERE c = 1 AND d >= 20", "tcd", true, 1},
+		{"SELECT d FROM t WHERE c = 1 AND b = 'z' AND d = 40", "tcd", false, 1},
+		{"SELECT * FROM t WHERE c = 2", "tcd", false, 1},
+		{"SELECT b FROM t WHERE a = 1 AND b = 'x'", "", false, 1},
+		{"SELECT b FROM t WHERE d = 10", "", false, 1},
+		{"SELECT b FROM t WHERE b = 'x' OR c = 1", "", false, 3},
+		{"SELECT c FROM t WHERE b LIKE 'x%'", "tb", true, 2},
+		{"SELECT c FROM t WHERE b GLOB 'y*'", "tb", true, 1},
+		// the text of the integer 12 matches too
+		{"SELECT c FROM t WHERE b LIKE '1%'", "tb", true, 1},
+		{"SELECT c FROM t WHERE b NOT LIKE 'x%'", "", false, 3},
+		{"SELECT c FROM t WHERE b LIKE '%x'", "", false, 2},
+		{"SELECT b FROM t", "", false, 5},
+	}
+
+	for _, tt := range tests {
+		l := lexer{content: tt.query}
+		p := parser{tokens: l.lex()}
+		parsed, err := p.pselect()
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", tt.query, err)
+		}
+
+		_, src, err := db.executor.planSelect(parsed.(*selectNode))
+		if err != nil {
+			t.Fatalf("Failed to plan %q: %v", tt.query, err)
+		}
+
+		index, covering := "", false
+		if is, ok := src.(*indexScan); ok {
+			index, covering = is.index.Name, is.covering
+		}
+		if index != tt.index || covering != tt.covering {
+			t.Errorf("%q: expected index %q covering %v, got %q %v", tt.query, tt.index, tt.covering, index, covering)
+		}
+
+		result, err := db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+		if len(result.rows) != tt.rows {
+			t.Errorf("%q: expected %d rows, got %v", tt.query, tt.rows, result.rows)
+		}
+	}
+
+	tbl, err := db.executor.storage.getTable("t")
+	if err != nil {
+		t.Fatalf("Failed to get table: %v", err)
+	}
+
+	decoded, err := decodeTable("t", encodeTable(tbl))
+	if err != nil {
+		t.Fatalf("Failed to decode table: %v", err)
+	}
+	if !reflect.DeepEqual(decoded.Indexes, tbl.Indexes) || decoded.NextIndexID != 2 {
+		t.Fatalf("Expected indexes %+v, got %+v", tbl.Indexes, decoded.Indexes)
+	}
+}
+
+func TestBufferedStore(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	storage := db.executor.storage.(*leveldbStorage)
+	base := storage.db
+	for _, k := range []string{"a", "c", "e", "g"} {
+		if err := base.Put([]byte(k), []byte("db"), nil); err != nil {
+			t.Fatalf("Failed to put %s: %v", k, err)
+		}
+	}
+
+	snap, err := base.GetSnapshot()
+	if err != nil {
+		t.Fatalf("Failed to get snapshot: %v", err)
+	}
+
+	// writes after the snapshot are not seen by the transaction
+	if err := base.Put([]byte("f"), []byte("db"), nil); err != nil {
+		t.Fatalf("Failed to put f: %v", err)
+	}
+
+	store := newBufferedStore(storage.clock, snap)
+	store.Put([]byte("b"), []byte("tx"), nil)
+	store.Put([]byte("c"), []byte("tx"), nil)
+	store.Delete([]byte("e"), nil)
+	store.Delete([]byte("f"), nil)
+	store.Put([]byte("h"), []byte("tx"), nil)
+
+	scan := func() []string {
+		iter := store.NewIterator(&util.Range{Start: []byte("a"), Limit: []byte("i")}, nil)
+		defer iter.Release()
+
+		var got []string
+		for iter.Next() {
+			got = append(got, string(iter.Key())+"="+string(iter.Value()))
+			// writes made while iterating are not seen
+			store.Put([]byte("d"), []byte("tx"), nil)
+		}
+		if err := iter.Error(); err != nil {
+			t.Fatalf("Iterator failed: %v", err)
+		}
+		return got
+	}
+
+	want := []string{"a=db", "b=tx", "c=tx", "g=db", "h=tx"}
+	if got := scan(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+
+	if v, err := store.Get([]byte("d"), nil); err != nil || string(v) != "tx" {
+		t.Fatalf("Expected own write, got %q %v", v, err)
+	}
+	if _, err := store.Get([]byte("e"), nil); err != leveldb.ErrNotFound {
+		t.Fatalf("Expected deleted key to be missing, got %v", err)
+	}
+	if ok, _ := base.Has([]byte("b"), nil); ok {
+		t.Fatalf("Uncommitted write is visible in the database")
+	}
+
+	if err := store.commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	iter := base.NewIterator(&util.Range{Start: []byte("a"), Limit: []byte("i")}, nil)
+	var got []string
+	for iter.Next() {
+		got = append(got, string(iter.Key())+"="+string(iter.Value()))
+	}
+	iter.Release()
+
+	// the delete of f from the transaction is written as well
+	want = []string{"a=db", "b=tx", "c=tx", "d=tx", "g=db", "h=tx"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v after commit, got %v", want, got)
+	}
+}
+
+func TestSnapshotStore(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	base := db.executor.storage.(*leveldbStorage)
+	if err := base.db.Put([]byte("a"), []byte("1"), nil); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+
+	stmt, err := base.begin(txStatement)
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	defer stmt.commit()
+	kv := stmt.(*leveldbTx).store
+
+	iter := kv.NewIterator(&util.Range{Start: []byte("a"), Limit: []byte("i")}, nil)
+	defer iter.Release()
+
+	// a write from outside isn't seen, a write of the statement is
+	if err := base.db.Put([]byte("b"), []byte("2"), nil); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if ok, _ := kv.Has([]byte("b"), nil); ok {
+		t.Fatalf("Statement sees a write made after it started")
+	}
+
+	if err := kv.Put([]byte("c"), []byte("3"), nil); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if ok, _ := kv.Has([]byte("c"), nil); !ok {
+		t.Fatalf("Statement doesn't see its own write")
+	}
+
+	// an iterator opened before the write doesn't see it
+	var keys []string
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	if !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Fatalf("Expected [a] from the iterator opened first, got %v", keys)
+	}
+
+	ro, err := base.begin(txReadOnly)
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	defer ro.rollback()
+	if err := ro.writeTable(&table{Name: "t"}); err != errReadOnly {
+		t.Fatalf("Expected %v, got %v", errReadOnly, err)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := db.Execute("CREATE TABLE t (id INTEGER PRIMARY KEY, name STRING)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	base := db.executor.storage.(*leveldbStorage)
+	tbl, err := base.getTable("t")
+	if err != nil {
+		t.Fatalf("Failed to get table: %v", err)
+	}
+
+	write := func(name string, ts uint64) {
+		t.Helper()
+		r := newRow(tbl)
+		r.Cells = []value{{ty: integerVal, integerVal: 1}, {ty: stringVal, stringVal: name}}
+		if err := base.writeRowAt("t", r, ts); err != nil {
+			t.Fatalf("Failed to write at %d: %v", ts, err)
+		}
+	}
+	write("a", 10)
+	write("b", 20)
+
+	key, err := primaryKeyOf(tbl, &row{table: tbl, Cells: []value{{ty: integerVal, integerVal: 1}}})
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	batch := new(leveldb.Batch)
+	batch.Delete(key)
+	if err := base.clock.write(batch, 30); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	read := func(ts uint64) string {
+		t.Helper()
+		r, err := base.getRow("t", key, ts)
+		if err != nil {
+			t.Fatalf("Failed to read at %d: %v", ts, err)
+		}
+		if r == nil {
+			return ""
+		}
+		return r.Cells[1].stringVal
+	}
+
+	for ts, want := range map[uint64]string{5: "", 10: "a", 15: "a", 25: "b", 35: "", latestTimestamp: ""} {
+		if got := read(ts); got != want {
+			t.Errorf("Expected %q at %d, got %q", want, ts, got)
+		}
+	}
+
+	iter, err := base.getRowRange("t", key, util.BytesPrefix(key).Limit, 15)
+	if err != nil {
+		t.Fatalf("Failed to get range: %v", err)
+	}
+	rows := 0
+	for _, ok := iter.Next(); ok; _, ok = iter.Next() {
+		rows++
+	}
+	iter.Close()
+	if rows != 1 {
+		t.Errorf("Expected 1 row at 15, got %d", rows)
+	}
+
+	// b is the version reads at 25 need, the delete at 30 drops every version
+	for _, tc := range []struct {
+		before  uint64
+		removed int
+	}{{25, 1}, {25, 0}, {35, 2}} {
+		removed, err := base.collectGarbage(tc.before)
+		if err != nil {
+			t.Fatalf("Failed to collect garbage: %v", err)
+		}
+		if removed != tc.removed {
+			t.Errorf("Expected %d versions removed before %d, got %d", tc.removed, tc.before, removed)
+		}
+	}
+	if got := read(25); got != "" {
+		t.Errorf("Expected no row at 25 after GC, got %q", got)
+	}
+}
+
+func TestVersionMigration(t *testing.T) {
+	dbPath := fmt.Sprintf("test_db_%d", rand.Int31())
+	defer os.RemoveAll(dbPath)
+
+	// a row written before rows had versions
+	ldb, err := leveldb.OpenFile(dbPath, nil)
+	if err != nil {
+		t.Fatalf("Failed to open leveldb: %v", err)
+	}
+	if err := ldb.Put([]byte("row_t_1"), []byte("old"), nil); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	ldb.Close()
+
+	s, err := NewStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer s.Close()
+	storage := s.(*leveldbStorage)
+
+	value, err := storage.kv.Get([]byte("row_t_1"), nil)
+	if err != nil || string(value) != "old" {
+		t.Fatalf("Expected the old row, got %q, %v", value, err)
+	}
+	if ok, _ := storage.db.Has([]byte("row_t_1"), nil); ok {
+		t.Errorf("Expected the unversioned key to be replaced")
+	}
+	if ok, _ := storage.db.Has(versionKey([]byte("row_t_1"), 1), nil); !ok {
+		t.Errorf("Expected the row to have the first version")
+	}
+}
+
+func TestResumedVersionMigration(t *testing.T) {
+	dbPath := fmt.Sprintf("test_db_%d", rand.Int31())
+	defer os.RemoveAll(dbPath)
+
+	// a migration that stopped after moving the first two rows
+	ldb, err := leveldb.OpenFile(dbPath, nil)
+	if err != nil {
+		t.Fatalf("Failed to open leveldb: %v", err)
+	}
+	batch := new(leveldb.Batch)
+	for _, key := range []string{"row_t_1", "row_t_2"} {
+		batch.Put(versionKey([]byte(key), 1), []byte{markWritten, 'm'})
+	}
+	batch.Put(migrateKey, []byte("row_t_2"))
+	batch.Put([]byte("row_t_3"), []byte("old"))
+	if err := ldb.Write(batch, nil); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	ldb.Close()
+
+	s, err := NewStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	defer s.Close()
+	storage := s.(*leveldbStorage)
+
+	var keys [][]byte
+	iter := storage.db.NewIterator(util.BytesPrefix([]byte(versionedPrefix)), nil)
+	for iter.Next() {
+		keys = append(keys, append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+
+	want := [][]byte{versionKey([]byte("row_t_1"), 1), versionKey([]byte("row_t_2"), 1), versionKey([]byte("row_t_3"), 1)}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("Expected a single version of every row, got %q", keys)
+	}
+	if ok, _ := storage.db.Has(migrateKey, nil); ok {
+		t.Errorf("Expected the migration progress to be removed")
+	}
+}
+
+func TestLegacyTableKeys(t *testing.T) {
+	dbPath := fmt.Sprintf("test_db_%d", rand.Int31())
+	defer os.RemoveAll(dbPath)
+
+	// a table with an underscore in its name from before tables had keys
+	ldb, err := leveldb.OpenFile(dbPath, nil)
+	if err != nil {
+		t.Fatalf("Failed to open leveldb: %v", err)
+	}
+	var catalog []byte
+	for _, s := range []string{"id", "integer", "name", "string"} {
+		catalog = appendBytes(catalog, []byte(s))
+	}
+	old := &row{Cells: []value{{ty: integerVal, integerVal: 1}, {ty: stringVal, stringVal: "Alice"}}}
+	batch := new(leveldb.Batch)
+	batch.Put([]byte("tbl_user_data_"), catalog)
+	batch.Put([]byte("row_user_data_0123456789abcdef"), encodeRow(old))
+	if err := ldb.Write(batch, nil); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	ldb.Close()
+
+	db, err := OpenDB(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Execute("INSERT INTO user_data VALUES (2, 'Bob')"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	result, err := db.Execute("SELECT id, name FROM user_data ORDER BY id")
+	if err != nil {
+		t.Fatalf("Failed to select: %v", err)
+	}
+	if want := [][]string{{"1", "Alice"}, {"2", "Bob"}}; !reflect.DeepEqual(result.rows, want) {
+		t.Errorf("Expected %v, got %v", want, result.rows)
+	}
+}
+
+func TestCompareSortKeys(t *testing.T) {
+	null := value{ty: nullVal}
+	one := value{ty: integerVal, integerVal: 1}
+	ten := value{ty: integerVal, integerVal: 10}
+	nine := value{ty: stringVal, stringVal: "9"}
+
+	tests := []struct {
+		name  string
+		terms []orderByTerm
+		a, b  value
+		want  int
+	}{
+		{"Integers compare numerically", []orderByTerm{{}}, ten, one, 1},
+		{"Integers sort before strings", []orderByTerm{{}}, ten, nine, -1},
+		{"Descending", []orderByTerm{{desc: true}}, one, ten, 1},
+		{"Nulls first by default when ascending", []orderByTerm{{}}, null, one, -1},
+		{"Nulls last by default when descending", []orderByTerm{{desc: true}}, null, one, 1},
+		{"Explicit nulls last", []orderByTerm{{nulls: nullsLast}}, null, one, 1},
+		{"Explicit nulls first when descending", []orderByTerm{{desc: true, nulls: nullsFirst}}, null, one, -1},
+		{"Equal", []orderByTerm{{}}, one, one, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareSortKeys(tt.terms, []value{tt.a}, []value{tt.b})
+			if got != tt.want {
+				t.Errorf("compareSortKeys() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+type countingStorage struct {
+	storage
+	scanned int
+}
+
+type countingIterator struct {
+	storageIterator
+	s *countingStorage
+}
+
+func (ci *countingIterator) Next() (*row, bool) {
+	r, ok := ci.storageIterator.Next()
+	if ok {
+		ci.s.scanned++
+	}
+	return r, ok
+}
+
+func (cs *countingStorage) getRowIterator(table string) (storageIterator, error) {
+	iter, err := cs.storage.getRowIterator(table)
+	if err != nil {
+		return nil, err
+	}
+	return &countingIterator{storageIterator: iter, s: cs}, nil
+}
+
+// countingTx counts the rows that a statement scans in its transaction.
+type countingTx struct {
+	txStorage
+	s *countingStorage
+}
+
+func (cs *countingStorage) begin(mode int) (txStorage, error) {
+	tx, err := cs.storage.begin(mode)
+	if err != nil {
+		return nil, err
+	}
+	return &countingTx{txStorage: tx, s: cs}, nil
+}
+
+func (ct *countingTx) getRowIterator(table string) (storageIterator, error) {
+	iter, err := ct.txStorage.getRowIterator(table)
+	if err != nil {
+		return nil, err
+	}
+	return &countingIterator{storageIterator: iter, s: ct.s}, nil
+}
+
+func TestLimitStopsScan(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	cs := &countingStorage{storage: db.executor.storage}
+	db.executor.storage = cs
+
+	if _, err := db.Execute("CREATE TABLE users (id INTEGER)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if _, err := db.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d)", i)); err != nil {
+			t.Fatalf("Failed to insert row: %v", err)
+		}
+	}
+
+	result, err := db.Execute("SELECT id FROM users LIMIT 5 OFFSET 2")
+	if err != nil {
+		t.Fatalf("Failed to select: %v", err)
+	}
+
+	if len(result.rows) != 5 {
+		t.Fatalf("Expected 5 rows, got %d", len(result.rows))
+	}
+
+	if cs.scanned != 7 {
+		t.Fatalf("Expected the scan to stop after 7 rows, scanned %d", cs.scanned)
+	}
+}
+
+func TestTopN(t *testing.T) {
+	top := &topN{terms: []orderByTerm{{desc: true}}, n: 3}
+	for i, v := range []int64{5, 1, 9, 3, 7, 9, 2} {
+		top.add(selectResult{sortKeys: []value{{ty: integerVal, integerVal: v}}, seq: i})
+	}
+
+	var got []int64
+	var seqs []int
+	for _, res := range top.sorted() {
+		got = append(got, res.sortKeys[0].integerVal)
+		seqs = append(seqs, res.seq)
+	}
+
+	if !reflect.DeepEqual(got, []int64{9, 9, 7}) || !reflect.DeepEqual(seqs, []int{2, 5, 4}) {
+		t.Fatalf("Unexpected top results %v with sequence numbers %v", got, seqs)
+	}
+}
+
+// memorySource is a row source over rows kept in memory, ordered says which
+// column the rows are sorted on.
+type memorySource struct {
+	table   *table
+	rows    [][]value
+	ordered int
+}
+
+func (ms *memorySource) schema() *table { return ms.table }
+func (ms *memorySource) size() int64    { return int64(len(ms.rows)) }
+func (ms *memorySource) orderedBy() int { return ms.ordered }
+
+func (ms *memorySource) open() (storageIterator, error) {
+	return &memoryIterator{source: ms}, nil
+}
+
+type memoryIterator struct {
+	source *memorySource
+	pos    int
+}
+
+func (mi *memoryIterator) Next() (*row, bool) {
+	if mi.pos >= len(mi.source.rows) {
+		return nil, false
+	}
+
+	r := newRow(mi.source.table)
+	r.Cells = append(r.Cells, mi.source.rows[mi.pos]...)
+	mi.pos++
+	return r, true
+}
+
+func (mi *memoryIterator) Close() error { return nil }
+
+func ident(name string) node {
+	return &literalNode{lit: token{tokType: identifierToken, content: name}}
+}
+
+func TestPlanJoin(t *testing.T) {
+	e := &exec{}
+	intVal := func(v int64) value { return value{ty: integerVal, integerVal: v} }
+	left := &memorySource{
+		table:   &table{Name: "a", Columns: []string{"k", "x"}, Types: []string{"INTEGER", "STRING"}},
+		rows:    [][]value{{intVal(1), {ty: stringVal, stringVal: "a1"}}, {{ty: nullVal}, {ty: stringVal, stringVal: "anull"}}},
+		ordered: -1,
+	}
+	right := &memorySource{
+		table: &table{Name: "b", Columns: []string{"k", "y"}, Types: []string{"INTEGER", "STRING"}},
+		rows: [][]value{
+			{intVal(1), {ty: stringVal, stringVal: "b1"}},
+			{intVal(1), {ty: stringVal, stringVal: "b1'"}},
+			{intVal(2), {ty: stringVal, stringVal: "b2"}},
+		},
+		ordered: -1,
+	}
+
+	equi := &binopNode{left: ident("b.k"), right: ident("a.k"), op: token{tokType: equalToken}}
+	theta := &binopNode{left: ident("a.k"), right: ident("b.k"), op: token{tokType: ltToken}}
+
+	src, err := e.planJoin(&joinNode{kind: fullJoin, on: equi}, left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hj, ok := src.(*hashJoin)
+	if !ok {
+		t.Fatalf("Expected a hash join for an equality, got %T", src)
+	}
+	if !hj.buildLeft {
+		t.Fatalf("Expected the hash join to build on the smaller left input")
+	}
+
+	if src, _ := e.planJoin(&joinNode{kind: innerJoin, on: theta}, left, right); reflect.TypeOf(src) != reflect.TypeOf(&nestedLoopJoin{}) {
+		t.Fatalf("Expected a nested loop join for a comparison, got %T", src)
+	}
+
+	run := func(src rowSource) []string {
+		iter, err := src.open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for {
+			r, ok := iter.Next()
+			if !ok {
+				break
+			}
+			got = append(got, fmt.Sprintf("%s/%s", r.Get("x").asStr(), r.Get("y").asStr()))
+			r.Release()
+		}
+		if err := iter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	want := []string{"a1/b1", "a1/b1'", "/b2", "anull/"}
+	if got := run(hj); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Hash join: expected %v, got %v", want, got)
+	}
+
+	// sorted inputs are merged, NULL keys sort first and never match.
+	left.rows[0], left.rows[1] = left.rows[1], left.rows[0]
+	left.ordered, right.ordered = 0, 0
+	src, err = e.planJoin(&joinNode{kind: fullJoin, on: equi}, left, right)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := src.(*mergeJoin); !ok {
+		t.Fatalf("Expected a merge join for sorted inputs, got %T", src)
+	}
+
+	want = []string{"anull/", "a1/b1", "a1/b1'", "/b2"}
+	if got := run(src); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Merge join: expected %v, got %v", want, got)
+	}
+}
+
+func TestLikePatterns(t *testing.T) {
+	tests := []struct {
+		glob    bool
+		pattern string
+		escape  string
+		input   string
+		want    bool
+	}{
+		{false, "a%", "", "abc", true},
+		{false, "a%", "", "bac", false},
+		{false, "%b%", "", "abc", true},
+		{false, "a_c", "", "abc", true},
+		{false, "a_c", "", "ac", false},
+		{false, "%%a%%b", "", "xaxxb", true},
+		{false, "%a%b", "", "ab_", false},
+		{false, "100!%", "!", "100%", true},
+		{false, "100!%", "!", "1000", false},
+		{false, "a!_b", "!", "a_b", true},
+		{false, "ä_", "", "äö", true},
+		{true, "*.go", "", "main.go", true},
+		{true, "?at", "", "cat", true},
+		{true, "[a-c]at", "", "bat", true},
+		{true, "[^a-c]at", "", "bat", false},
+		{true, "[]x]", "", "]", true},
+		{true, "A*", "", "abc", false},
+	}
+
+	for _, tt := range tests {
+		var elems []patternElem
+		var err error
+		if tt.glob {
+			elems, err = compileGlob(tt.pattern)
+		} else {
+			elems, err = compileLike(tt.pattern, tt.escape)
+		}
+		if err != nil {
+			t.Fatalf("%q: %s", tt.pattern, err)
+		}
+
+		if got := matchPattern(elems, []rune(tt.input)); got != tt.want {
+			t.Errorf("%q on %q: got %v, want %v", tt.pattern, tt.input, got, tt.want)
+		}
+	}
+
+	if _, err := compileLike("abc!", "!"); err == nil {
+		t.Errorf("Expected an error for a pattern ending with the escape character")
+	}
+	if _, err := compileGlob("[abc"); err == nil {
+		t.Errorf("Expected an error for an unterminated character class")
+	}
+
+	for _, tt := range []struct {
+		query  string
+		prefix string
+		ok     bool
+	}{
+		{"name LIKE 'ab%c'", "ab", true},
+		{"name LIKE 'a!%b%' ESCAPE '!'", "a%b", true},
+		{"name GLOB 'x?y'", "x", true},
+		{"name LIKE '%ab'", "", false},
+		{"name ILIKE 'ab%'", "", false},
+		{"name LIKE pattern", "", false},
+		{"name LIKE 'a%' ESCAPE 'ab'", "", false},
+	} {
+		l := lexer{content: tt.query}
+		p := parser{tokens: l.lex()}
+		exp, err := p.expr()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		prefix, ok := likePrefix(exp.(*likeNode))
+		if prefix != tt.prefix || ok != tt.ok {
+			t.Errorf("%q: got prefix %q %v, want %q %v", tt.query, prefix, ok, tt.prefix, tt.ok)
+		}
+	}
+}
+
 type mockStorage struct {
 	tables map[string]*table
 	rows   map[string][]*row
@@ -0,0 +1,211 @@
+package levelsql
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// findAggregates returns the aggregate calls of a select. Aggregates are allowed
+// in the select list, HAVING and ORDER BY but not in WHERE or GROUP BY, and they
+// can't be nested.
+func findAggregates(sn *selectNode) ([]*functionCallNode, error) {
+	var aggregates []*functionCallNode
+	var err error
+	collect := func(n node) bool {
+		fcn, ok := n.(*functionCallNode)
+		if !ok || !isAggregate(fcn) {
+			return true
+		}
+
+		if argErr := checkAggregateArgs(fcn); argErr != nil {
+			err = argErr
+		}
+
+		for _, arg := range fcn.args {
+			walk(arg, func(inner node) bool {
+				if call, ok := inner.(*functionCallNode); ok && isAggregate(call) {
+					err = fmt.Errorf("aggregate function calls cannot be nested: %s", fcn)
+				}
+				return true
+			})
+		}
+
+		for i, arg := range fcn.args {
+			if isStar(arg) && (i > 0 || len(fcn.args) > 1 || strings.ToLower(fcn.name.content) != "count") {
+				err = fmt.Errorf("* is only allowed as the only argument of count")
+			}
+		}
+
+		aggregates = append(aggregates, fcn)
+		return false
+	}
+
+	for _, col := range sn.columns {
+		walk(col, collect)
+	}
+	walk(sn.having, collect)
+	for _, term := range sn.orderBy {
+		walk(term.expr, collect)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	misplaced := func(n node) bool {
+		if fcn, ok := n.(*functionCallNode); ok && isAggregate(fcn) {
+			err = errors.New("aggregate functions are not allowed in WHERE or GROUP BY")
+		}
+		return true
+	}
+	walk(sn.where, misplaced)
+	for _, expr := range sn.groupBy {
+		walk(expr, misplaced)
+	}
+
+	return aggregates, err
+}
+
+// group holds the aggregate states of a group along with the first row of the
+// group. Columns that aren't grouped on are read from that row.
+type group struct {
+	first  *row
+	states []aggregateState
+}
+
+func (e *exec) newGroup(r *row, aggregates []*functionCallNode) *group {
+	g := &group{
+		first:  &row{table: r.table, Cells: append([]value(nil), r.Cells...)},
+		states: make([]aggregateState, len(aggregates)),
+	}
+
+	for i, fcn := range aggregates {
+		state := aggregateFuncs[strings.ToLower(fcn.name.content)].new()
+		if fcn.distinct {
+			state = &distinctAggregate{inner: state, seen: e.newDistinctSet()}
+		}
+		g.states[i] = state
+	}
+
+	return g
+}
+
+// Close releases the scratch spaces used by the aggregates of the group.
+func (g *group) Close() error {
+	var firstErr error
+	for _, state := range g.states {
+		if c, ok := state.(io.Closer); ok {
+			if err := c.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (e *exec) stepGroup(g *group, r *row, aggregates []*functionCallNode) error {
+	for i, fcn := range aggregates {
+		var args []value
+		if len(fcn.args) != 1 || !isStar(fcn.args[0]) {
+			var err error
+			args, err = executeArgs(e, r, fcn.args)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := g.states[i].step(args); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// executeGrouped groups the matching rows by the GROUP BY expressions and passes
+// one row per group that satisfies HAVING to the output. Without GROUP BY all
+// rows form a single group, even when there are no rows.
+func (e *exec) executeGrouped(sn *selectNode, src rowSource, aggregates []*functionCallNode, out *selectOutput) error {
+	groupBy := make([]node, len(sn.groupBy))
+	for i, expr := range sn.groupBy {
+		ordinal, err := columnOrdinal(expr, sn.columns)
+		if err != nil {
+			return err
+		}
+
+		groupBy[i] = expr
+		if ordinal != -1 {
+			groupBy[i] = sn.columns[ordinal]
+		}
+	}
+
+	groups := make(map[string]*group)
+	var order []*group
+	defer func() {
+		for _, g := range order {
+			g.Close()
+		}
+	}()
+	keyVals := make([]value, len(groupBy))
+	err := e.scanRows(src, sn.where, func(r *row) (bool, error) {
+		for i, expr := range groupBy {
+			val, err := e.executeExpression(expr, r)
+			if err != nil {
+				return false, err
+			}
+			keyVals[i] = val
+		}
+
+		key := valuesKey(keyVals)
+		g, ok := groups[key]
+		if !ok {
+			g = e.newGroup(r, aggregates)
+			groups[key] = g
+			order = append(order, g)
+		}
+
+		return true, e.stepGroup(g, r, aggregates)
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(order) == 0 && len(groupBy) == 0 {
+		empty := &row{table: src.schema()}
+		for range empty.table.Columns {
+			empty.Append(value{ty: nullVal})
+		}
+		order = append(order, e.newGroup(empty, aggregates))
+	}
+
+	for _, g := range order {
+		g.first.aggregates = make(map[*functionCallNode]value, len(aggregates))
+		for i, fcn := range aggregates {
+			g.first.aggregates[fcn] = g.states[i].finalize()
+		}
+
+		if sn.having != nil {
+			val, err := e.executeExpression(sn.having, g.first)
+			if err != nil {
+				return fmt.Errorf("something went wrong when executing having: %s", err)
+			}
+
+			if !val.asBool() {
+				continue
+			}
+		}
+
+		more, err := out.add(e, g.first)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,437 @@
+package levelsql
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+)
+
+const (
+	// hashJoinPartitions is the number of partitions both inputs are split into
+	// once the build side doesn't fit into the memory budget.
+	hashJoinPartitions = 16
+	hashEntryOverhead  = 64
+
+	buildPartition byte = 'b'
+	probePartition byte = 'p'
+)
+
+// hashJoin joins on equality conditions by loading one input, the build side,
+// into a hash table and looking up the rows of the other input, the probe side,
+// in it. The smaller input is used as the build side.
+//
+// When the build side uses more than the memory budget both inputs are split by
+// the hash of their key into partitions in a scratch space. Matching rows always
+// end up in the same partition, so the partitions are then joined one by one.
+type hashJoin struct {
+	e                   *exec
+	kind                joinKind
+	left, right         rowSource
+	leftKeys, rightKeys []node
+	residual            []node
+	joined              *table
+	buildLeft           bool
+}
+
+func (hj *hashJoin) schema() *table {
+	return hj.joined
+}
+
+func (hj *hashJoin) size() int64 {
+	return hj.left.size() + hj.right.size()
+}
+
+func (hj *hashJoin) open() (storageIterator, error) {
+	keepLeft, keepRight := hj.kind.keepsLeft(), hj.kind.keepsRight()
+	it := &hashJoinIterator{
+		join:        hj,
+		build:       hj.right,
+		probe:       hj.left,
+		buildKeys:   hj.rightKeys,
+		probeKeys:   hj.leftKeys,
+		keepBuild:   keepRight,
+		keepProbe:   keepLeft,
+		leftColumns: len(hj.left.schema().Columns),
+	}
+	if hj.buildLeft {
+		it.build, it.probe = hj.left, hj.right
+		it.buildKeys, it.probeKeys = hj.leftKeys, hj.rightKeys
+		it.keepBuild, it.keepProbe = keepLeft, keepRight
+	}
+
+	if err := it.load(); err != nil {
+		it.Close()
+		return nil, err
+	}
+	return it, nil
+}
+
+// hashEntry is a row of the build side. Rows with a NULL key never match, they
+// are only kept to emit them for outer joins.
+type hashEntry struct {
+	cells   []value
+	key     string
+	hasKey  bool
+	matched bool
+}
+
+func (he *hashEntry) memory() int {
+	size := hashEntryOverhead + len(he.key)
+	for _, cell := range he.cells {
+		size += len(cell.stringVal) + 32
+	}
+	return size
+}
+
+type hashJoinIterator struct {
+	join                 *hashJoin
+	build, probe         rowSource
+	buildKeys, probeKeys []node
+	keepBuild, keepProbe bool
+	leftColumns          int
+
+	table   map[string][]*hashEntry
+	entries []*hashEntry
+	used    int
+
+	// probeIter is used while the build side fits into memory, after spilling
+	// the probe rows of the current partition are read from partIter.
+	probeIter storageIterator
+	scratch   scratchSpace
+	partition int
+	partIter  iterator.Iterator
+	seq       uint64
+
+	unmatchedDone bool
+	pending       []*row
+	err           error
+}
+
+func (it *hashJoinIterator) key(keys []node, r *row) (string, bool, error) {
+	vals := make([]value, len(keys))
+	for i, k := range keys {
+		val, err := it.join.e.executeExpression(k, r)
+		if err != nil {
+			return "", false, err
+		}
+		if val.ty == nullVal {
+			return "", false, nil
+		}
+		vals[i] = val
+	}
+	return valuesKey(vals), true, nil
+}
+
+func (it *hashJoinIterator) add(entry *hashEntry) {
+	if entry.hasKey {
+		it.table[entry.key] = append(it.table[entry.key], entry)
+	}
+	it.entries = append(it.entries, entry)
+	it.used += entry.memory()
+}
+
+// load reads the build side into the hash table, or into the partitions of a
+// scratch space if it doesn't fit.
+func (it *hashJoinIterator) load() error {
+	it.table = make(map[string][]*hashEntry)
+
+	iter, err := it.build.open()
+	if err != nil {
+		return err
+	}
+
+	for {
+		r, ok := iter.Next()
+		if !ok {
+			break
+		}
+
+		key, hasKey, err := it.key(it.buildKeys, r)
+		if err != nil {
+			r.Release()
+			iter.Close()
+			return err
+		}
+
+		entry := &hashEntry{cells: append([]value(nil), r.Cells...), key: key, hasKey: hasKey}
+		r.Release()
+
+		if it.scratch != nil {
+			err = it.spill(buildPartition, entry)
+		} else {
+			it.add(entry)
+			if it.used > it.join.e.budget() {
+				err = it.spillAll()
+			}
+		}
+		if err != nil {
+			iter.Close()
+			return err
+		}
+	}
+
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	probeIter, err := it.probe.open()
+	if err != nil {
+		return err
+	}
+
+	if it.scratch == nil {
+		it.probeIter = probeIter
+		return nil
+	}
+
+	// the probe side is partitioned the same way as the build side.
+	for {
+		r, ok := probeIter.Next()
+		if !ok {
+			break
+		}
+
+		key, hasKey, err := it.key(it.probeKeys, r)
+		if err == nil {
+			err = it.spill(probePartition, &hashEntry{cells: r.Cells, key: key, hasKey: hasKey})
+		}
+		r.Release()
+		if err != nil {
+			probeIter.Close()
+			return err
+		}
+	}
+
+	if err := probeIter.Close(); err != nil {
+		return err
+	}
+
+	return it.loadPartition(0)
+}
+
+// spillAll moves the hash table to a new scratch space. The rest of the build
+// side is written directly to the partitions.
+func (it *hashJoinIterator) spillAll() error {
+	scratch, err := it.join.e.storage.newScratch()
+	if err != nil {
+		return err
+	}
+	it.scratch = scratch
+
+	for _, entry := range it.entries {
+		if err := it.spill(buildPartition, entry); err != nil {
+			return err
+		}
+	}
+
+	it.table = make(map[string][]*hashEntry)
+	it.entries = nil
+	it.used = 0
+	return nil
+}
+
+// partitionKey is the side, the partition and a sequence number that keeps the
+// rows of a partition in the order they were written.
+func (it *hashJoinIterator) partitionKey(side byte, partition int) []byte {
+	key := []byte{side, byte(partition)}
+	key = binary.BigEndian.AppendUint64(key, it.seq)
+	it.seq++
+	return key
+}
+
+func (it *hashJoinIterator) spill(side byte, entry *hashEntry) error {
+	partition := 0
+	if entry.hasKey {
+		h := fnv.New32a()
+		h.Write([]byte(entry.key))
+		partition = int(h.Sum32() % hashJoinPartitions)
+	}
+
+	var value []byte
+	if entry.hasKey {
+		value = append(value, 1)
+	} else {
+		value = append(value, 0)
+	}
+	value = appendBytes(value, []byte(entry.key))
+	value = append(value, encodeRow(&row{Cells: entry.cells})...)
+
+	return it.scratch.put(it.partitionKey(side, partition), value)
+}
+
+func decodeHashEntry(schema *table, value []byte) *hashEntry {
+	r := &byteReader{buf: value, offset: 1}
+	entry := &hashEntry{hasKey: len(value) > 0 && value[0] == 1, key: string(r.bytes())}
+
+	cells := &row{table: schema}
+	decodeCells(cells, value[r.offset:], nil)
+	entry.cells = cells.Cells
+	return entry
+}
+
+// loadPartition reads the build rows of a partition into the hash table and
+// starts reading its probe rows. A single partition is assumed to fit into
+// memory.
+func (it *hashJoinIterator) loadPartition(partition int) error {
+	if it.partIter != nil {
+		it.partIter.Release()
+		it.partIter = nil
+	}
+
+	it.partition = partition
+	it.unmatchedDone = false
+	it.table = make(map[string][]*hashEntry)
+	it.entries = nil
+	it.used = 0
+
+	iter := it.scratch.scan([]byte{buildPartition, byte(partition)})
+	for iter.Next() {
+		it.add(decodeHashEntry(it.build.schema(), iter.Value()))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	it.partIter = it.scratch.scan([]byte{probePartition, byte(partition)})
+	return nil
+}
+
+// nextProbe returns the next row of the probe side with its key.
+func (it *hashJoinIterator) nextProbe() (*row, string, bool, bool) {
+	if it.scratch == nil {
+		r, ok := it.probeIter.Next()
+		if !ok {
+			return nil, "", false, false
+		}
+
+		key, hasKey, err := it.key(it.probeKeys, r)
+		if err != nil {
+			r.Release()
+			it.err = err
+			return nil, "", false, false
+		}
+		return r, key, hasKey, true
+	}
+
+	if !it.partIter.Next() {
+		if err := it.partIter.Error(); err != nil {
+			it.err = err
+		}
+		return nil, "", false, false
+	}
+
+	entry := decodeHashEntry(it.probe.schema(), it.partIter.Value())
+	r := newRow(it.probe.schema())
+	r.Cells = append(r.Cells, entry.cells...)
+	return r, entry.key, entry.hasKey, true
+}
+
+// combine builds a joined row from a build and a probe row, either can be nil.
+func (it *hashJoinIterator) combine(build []value, probe *row) *row {
+	var b *row
+	if build != nil {
+		b = &row{Cells: build}
+	}
+
+	if it.join.buildLeft {
+		return combineRows(it.join.joined, it.leftColumns, b, probe)
+	}
+	return combineRows(it.join.joined, it.leftColumns, probe, b)
+}
+
+func (it *hashJoinIterator) probeRow(r *row, key string, hasKey bool) error {
+	matched := false
+	if hasKey {
+		for _, entry := range it.table[key] {
+			combined := it.combine(entry.cells, r)
+			ok, err := it.join.e.matchesAll(it.join.residual, combined)
+			if err != nil {
+				combined.Release()
+				return err
+			}
+			if !ok {
+				combined.Release()
+				continue
+			}
+
+			entry.matched = true
+			matched = true
+			it.pending = append(it.pending, combined)
+		}
+	}
+
+	if !matched && it.keepProbe {
+		it.pending = append(it.pending, it.combine(nil, r))
+	}
+	return nil
+}
+
+func (it *hashJoinIterator) Next() (*row, bool) {
+	for {
+		if len(it.pending) > 0 {
+			r := it.pending[0]
+			it.pending[0] = nil
+			it.pending = it.pending[1:]
+			return r, true
+		}
+
+		if it.err != nil {
+			return nil, false
+		}
+
+		r, key, hasKey, ok := it.nextProbe()
+		if ok {
+			it.err = it.probeRow(r, key, hasKey)
+			r.Release()
+			continue
+		}
+		if it.err != nil {
+			return nil, false
+		}
+
+		if it.keepBuild && !it.unmatchedDone {
+			it.unmatchedDone = true
+			for _, entry := range it.entries {
+				if !entry.matched {
+					it.pending = append(it.pending, it.combine(entry.cells, nil))
+				}
+			}
+			continue
+		}
+
+		if it.scratch != nil && it.partition+1 < hashJoinPartitions {
+			it.err = it.loadPartition(it.partition + 1)
+			continue
+		}
+
+		return nil, false
+	}
+}
+
+func (it *hashJoinIterator) Close() error {
+	for _, r := range it.pending {
+		r.Release()
+	}
+	it.pending = nil
+
+	err := it.err
+	if it.probeIter != nil {
+		if closeErr := it.probeIter.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if it.partIter != nil {
+		it.partIter.Release()
+	}
+
+	if it.scratch != nil {
+		if closeErr := it.scratch.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,392 @@
+package levelsql
+
+import (
+	"fmt"
+)
+
+// joinSchema combines the columns of both sides of a join. Every column keeps
+// the qualifier of the side it came from.
+func joinSchema(left, right *table) *table {
+	combined := &table{}
+	for _, side := range []*table{left, right} {
+		for i, col := range side.Columns {
+			combined.Columns = append(combined.Columns, col)
+			combined.Types = append(combined.Types, side.Types[i])
+			combined.qualifiers = append(combined.qualifiers, side.qualifier(i))
+		}
+	}
+	return combined
+}
+
+// combineRows builds a row of the join schema. A nil side is filled with NULLs,
+// which is how outer joins extend rows without a match.
+func combineRows(schema *table, leftColumns int, left, right *row) *row {
+	r := newRow(schema)
+	for i := 0; i < leftColumns; i++ {
+		if left != nil && i < len(left.Cells) {
+			r.Append(left.Cells[i])
+		} else {
+			r.Append(value{ty: nullVal})
+		}
+	}
+
+	for i := 0; i < len(schema.Columns)-leftColumns; i++ {
+		if right != nil && i < len(right.Cells) {
+			r.Append(right.Cells[i])
+		} else {
+			r.Append(value{ty: nullVal})
+		}
+	}
+
+	return r
+}
+
+// equiPair is a condition of the form left = right where each side only uses
+// the columns of one input of the join.
+type equiPair struct {
+	left, right node
+	cond        node
+}
+
+// conjuncts splits a condition into the parts that all have to hold.
+func conjuncts(n node) []node {
+	if n == nil {
+		return nil
+	}
+
+	if bn, ok := n.(*binopNode); ok && bn.op.tokType == andToken {
+		return append(conjuncts(bn.left), conjuncts(bn.right)...)
+	}
+	return []node{n}
+}
+
+// columnSide reports which input of a join the columns of n come from: 1 for
+// the left, 2 for the right, 0 if n uses no columns and -1 if it uses both or
+// a column that can't be resolved to exactly one of them.
+func columnSide(n node, left, right *table) int {
+	side := 0
+	walk(n, func(n node) bool {
+		lit, ok := n.(*literalNode)
+		if !ok || lit.lit.tokType != identifierToken {
+			return true
+		}
+
+		inLeft := left.fieldIndex(lit.lit.content) != -1
+		inRight := right.fieldIndex(lit.lit.content) != -1
+
+		s := -1
+		if inLeft && !inRight {
+			s = 1
+		} else if inRight && !inLeft {
+			s = 2
+		}
+
+		if side == 0 {
+			side = s
+		} else if side != s {
+			side = -1
+		}
+		return true
+	})
+	return side
+}
+
+// equiJoinKeys finds the equality conditions of an ON clause that can be used
+// as join keys. The rest of the clause is returned as the residual conditions
+// that are checked on the joined rows.
+func equiJoinKeys(on node, left, right *table) (pairs []equiPair, residual []node) {
+	for _, cond := range conjuncts(on) {
+		bn, ok := cond.(*binopNode)
+		if !ok || bn.op.tokType != equalToken {
+			residual = append(residual, cond)
+			continue
+		}
+
+		l, r := columnSide(bn.left, left, right), columnSide(bn.right, left, right)
+		switch {
+		case l == 1 && r == 2:
+			pairs = append(pairs, equiPair{left: bn.left, right: bn.right, cond: cond})
+		case l == 2 && r == 1:
+			pairs = append(pairs, equiPair{left: bn.right, right: bn.left, cond: cond})
+		default:
+			residual = append(residual, cond)
+		}
+	}
+	return pairs, residual
+}
+
+// orderedOn reports whether the rows of src are sorted on the column key.
+func orderedOn(src rowSource, key node) bool {
+	os, ok := src.(orderedSource)
+	if !ok {
+		return false
+	}
+
+	lit, ok := key.(*literalNode)
+	if !ok || lit.lit.tokType != identifierToken {
+		return false
+	}
+
+	idx := os.orderedBy()
+	return idx != -1 && os.schema().fieldIndex(lit.lit.content) == idx
+}
+
+// planJoin picks the join operator from the shape of the ON clause. Equality
+// conditions between the two inputs are joined with a merge join when both
+// inputs are already sorted on a key and with a hash join otherwise. All other
+// conditions fall back to a nested loop.
+func (e *exec) planJoin(jn *joinNode, left, right rowSource) (rowSource, error) {
+	joined := joinSchema(left.schema(), right.schema())
+
+	var pairs []equiPair
+	var residual []node
+	if jn.kind != crossJoin {
+		pairs, residual = equiJoinKeys(jn.on, left.schema(), right.schema())
+	}
+
+	if len(pairs) == 0 {
+		return &nestedLoopJoin{
+			e:      e,
+			kind:   jn.kind,
+			left:   left,
+			right:  right,
+			on:     jn.on,
+			joined: joined,
+		}, nil
+	}
+
+	for i, pair := range pairs {
+		if !orderedOn(left, pair.left) || !orderedOn(right, pair.right) {
+			continue
+		}
+
+		// the other key pairs are checked like any other condition.
+		for j, other := range pairs {
+			if j != i {
+				residual = append(residual, other.cond)
+			}
+		}
+
+		return &mergeJoin{
+			e:        e,
+			kind:     jn.kind,
+			left:     left,
+			right:    right,
+			leftKey:  pair.left,
+			rightKey: pair.right,
+			residual: residual,
+			joined:   joined,
+		}, nil
+	}
+
+	hj := &hashJoin{
+		e:         e,
+		kind:      jn.kind,
+		left:      left,
+		right:     right,
+		residual:  residual,
+		joined:    joined,
+		buildLeft: left.size() < right.size(),
+	}
+	for _, pair := range pairs {
+		hj.leftKeys = append(hj.leftKeys, pair.left)
+		hj.rightKeys = append(hj.rightKeys, pair.right)
+	}
+	return hj, nil
+}
+
+// matchesAll checks the residual conditions of a join on a joined row.
+func (e *exec) matchesAll(conds []node, r *row) (bool, error) {
+	for _, cond := range conds {
+		val, err := e.executeExpression(cond, r)
+		if err != nil {
+			return false, fmt.Errorf("something went wrong when executing on: %s", err)
+		}
+		if !val.asBool() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// nestedLoopJoin joins by scanning the right side once for every row on the
+// left side. It works for any ON condition.
+type nestedLoopJoin struct {
+	e           *exec
+	kind        joinKind
+	left, right rowSource
+	on          node
+	joined      *table
+}
+
+func (nl *nestedLoopJoin) schema() *table {
+	return nl.joined
+}
+
+func (nl *nestedLoopJoin) size() int64 {
+	return nl.left.size() + nl.right.size()
+}
+
+func (nl *nestedLoopJoin) open() (storageIterator, error) {
+	leftIter, err := nl.left.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return &nestedLoopIterator{
+		join:        nl,
+		leftIter:    leftIter,
+		leftColumns: len(nl.left.schema().Columns),
+	}, nil
+}
+
+type nestedLoopIterator struct {
+	join *nestedLoopJoin
+
+	leftIter    storageIterator
+	rightIter   storageIterator
+	leftColumns int
+	left        *row
+	leftMatched bool
+
+	// right rows are identified by their position in the scan, for right and
+	// full joins the unmatched ones are emitted after the left side runs out.
+	rightPos      int
+	rightMatched  []bool
+	emitUnmatched bool
+
+	err error
+}
+
+func (it *nestedLoopIterator) fail(err error) (*row, bool) {
+	it.err = err
+	return nil, false
+}
+
+func (it *nestedLoopIterator) openRight() error {
+	if it.rightIter != nil {
+		if err := it.rightIter.Close(); err != nil {
+			return err
+		}
+	}
+
+	rightIter, err := it.join.right.open()
+	if err != nil {
+		return err
+	}
+
+	it.rightIter = rightIter
+	it.rightPos = -1
+	return nil
+}
+
+func (it *nestedLoopIterator) Next() (*row, bool) {
+	if it.err != nil {
+		return nil, false
+	}
+	schema := it.join.joined
+
+	for {
+		if it.emitUnmatched {
+			r, ok := it.rightIter.Next()
+			if !ok {
+				return nil, false
+			}
+
+			it.rightPos++
+			matched := it.rightPos < len(it.rightMatched) && it.rightMatched[it.rightPos]
+			if matched {
+				r.Release()
+				continue
+			}
+
+			combined := combineRows(schema, it.leftColumns, nil, r)
+			r.Release()
+			return combined, true
+		}
+
+		if it.left == nil {
+			l, ok := it.leftIter.Next()
+			if !ok {
+				if !it.join.kind.keepsRight() {
+					return nil, false
+				}
+
+				it.emitUnmatched = true
+				if err := it.openRight(); err != nil {
+					return it.fail(err)
+				}
+				continue
+			}
+
+			it.left = l
+			it.leftMatched = false
+			if err := it.openRight(); err != nil {
+				return it.fail(err)
+			}
+		}
+
+		r, ok := it.rightIter.Next()
+		if !ok {
+			l := it.left
+			it.left = nil
+			if !it.leftMatched && it.join.kind.keepsLeft() {
+				combined := combineRows(schema, it.leftColumns, l, nil)
+				l.Release()
+				return combined, true
+			}
+
+			l.Release()
+			continue
+		}
+
+		it.rightPos++
+		combined := combineRows(schema, it.leftColumns, it.left, r)
+		r.Release()
+
+		match := true
+		if it.join.on != nil {
+			val, err := it.join.e.executeExpression(it.join.on, combined)
+			if err != nil {
+				combined.Release()
+				return it.fail(fmt.Errorf("something went wrong when executing on: %s", err))
+			}
+			match = val.asBool()
+		}
+
+		if !match {
+			combined.Release()
+			continue
+		}
+
+		it.leftMatched = true
+		if it.join.kind.keepsRight() {
+			for len(it.rightMatched) <= it.rightPos {
+				it.rightMatched = append(it.rightMatched, false)
+			}
+			it.rightMatched[it.rightPos] = true
+		}
+
+		return combined, true
+	}
+}
+
+func (it *nestedLoopIterator) Close() error {
+	if it.left != nil {
+		it.left.Release()
+		it.left = nil
+	}
+
+	err := it.err
+	if it.rightIter != nil {
+		if closeErr := it.rightIter.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if closeErr := it.leftIter.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,78 @@
+package levelsql
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// tablePrefix is the start of the keys of a table in the keyspace kind, like
+// "row_users_" for the rows of the table with the key users.
+func tablePrefix(kind string, t *table) []byte {
+	return []byte(kind + "_" + t.Key + "_")
+}
+
+// newTableKey returns the key of a new table. Rows and index entries are stored
+// under the key of their table instead of its name, so renaming a table only
+// rewrites its catalog entry. A new key starts with 0x00, which no name does,
+// and has a fixed width, so the keys of one table never start with the prefix
+// of another.
+func newTableKey() string {
+	key := make([]byte, 9)
+	rand.Read(key[1:])
+	return string(key)
+}
+
+// legacyTableKey is the key of a table whose catalog entry is from before tables
+// had keys. It is the name of the table, which its rows were stored under.
+func legacyTableKey(name string) string {
+	return name
+}
+
+func rowPrefix(t *table) []byte {
+	return tablePrefix("row", t)
+}
+
+func catalogKey(table string) []byte {
+	return []byte("tbl_" + table + "_")
+}
+
+// Key values start with a tag that orders the types like compareValues does, so
+// the encoded tuples of a primary key sort in the same order as the values.
+const (
+	keyNull byte = iota + 1
+	keyFalse
+	keyTrue
+	keyInteger
+	keyString
+)
+
+// appendKeyValue appends the order preserving encoding of a value. Integers are
+// stored big endian with the sign bit flipped, so negative numbers come first.
+// Strings end in 0x00 0x01 and a 0x00 inside the string becomes 0x00 0xff, which
+// keeps a string before every longer string it is a prefix of. Every value knows
+// where it ends, so the key of a tuple is a prefix of the keys of the longer
+// tuples that start with the same values.
+func appendKeyValue(b []byte, v value) []byte {
+	switch v.ty {
+	case boolVal:
+		if v.boolVal {
+			return append(b, keyTrue)
+		}
+		return append(b, keyFalse)
+	case integerVal:
+		b = append(b, keyInteger)
+		return binary.BigEndian.AppendUint64(b, uint64(v.integerVal)^(1<<63))
+	case stringVal:
+		b = append(b, keyString)
+		for i := 0; i < len(v.stringVal); i++ {
+			if v.stringVal[i] == 0x00 {
+				b = append(b, 0x00, 0xff)
+			} else {
+				b = append(b, v.stringVal[i])
+			}
+		}
+		return append(b, 0x00, 0x01)
+	default:
+		return append(b, keyNull)
+	}
+}
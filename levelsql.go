@@ -1,9 +1,18 @@
 package levelsql
 
+import (
+	"errors"
+	"time"
+)
+
 // This package contains the usable interface that can be embedded into code.
 type DB struct {
 	executor *exec
 	parser   *parser
+
+	// tx is the transaction started with a BEGIN statement, the statements
+	// given to Execute run in it until it ends.
+	tx *Tx
 }
 
 // OpenDB opens up a database for a file path.
@@ -21,11 +30,33 @@ func OpenDB(dbpath string) (*DB, error) {
 	}, nil
 }
 
+// SetMemoryBudget sets the amount of memory in bytes that a single operator, like
+// the duplicate tracking of DISTINCT, can use before it spills to disk.
+func (d *DB) SetMemoryBudget(bytes int) {
+	d.executor.memoryBudget = bytes
+}
+
+// SetGCTTL sets how long the old versions of rows are kept, AS OF SYSTEM TIME
+// can read as far back as the TTL.
+func (d *DB) SetGCTTL(ttl time.Duration) {
+	d.executor.gcTTL = ttl
+}
+
+// GC removes the versions of rows that are older than the GC TTL and that no
+// query can read anymore. It returns the number of versions removed.
+func (d *DB) GC() (int, error) {
+	before := time.Now().Add(-d.executor.ttl())
+	return d.executor.storage.collectGarbage(uint64(before.UnixNano()))
+}
+
 func (d *DB) Close() error {
+	if d.tx != nil {
+		d.tx.Rollback()
+	}
 	return d.executor.storage.Close()
 }
 
-func (d *DB) Execute(query string) (*QueryResponse, error) {
+func (d *DB) parse(query string) (node, error) {
 	lexer := lexer{
 		index:   0,
 		content: query,
@@ -33,10 +64,171 @@ func (d *DB) Execute(query string) (*QueryResponse, error) {
 	tokens := lexer.lex()
 
 	d.parser.reset(tokens)
-	root, err := d.parser.parse()
+	return d.parser.parse()
+}
+
+// Execute runs a statement. Outside of a transaction every statement reads from
+// a snapshot of the database, so its results are as of when it started, and its
+// writes are committed together once it succeeds. A statement that fails
+// writes nothing.
+func (d *DB) Execute(query string) (*QueryResponse, error) {
+	if d.tx != nil {
+		res, err := d.tx.Execute(query)
+		if d.tx.done {
+			d.tx = nil
+		}
+		return res, err
+	}
+
+	root, err := d.parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if tn, ok := root.(*transactionNode); ok {
+		if tn.action != beginToken {
+			return nil, errNoTransaction
+		}
+
+		mode := txReadWrite
+		if tn.readOnly {
+			mode = txReadOnly
+		}
+
+		if d.tx, err = d.begin(mode); err != nil {
+			return nil, err
+		}
+		return &QueryResponse{empty: true}, nil
+	}
+
+	stmt, err := d.begin(txStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := stmt.executor.execute(root)
+	if err != nil {
+		stmt.Rollback()
+		return nil, err
+	}
+
+	if err := stmt.Commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+var (
+	errNoTransaction = errors.New("no transaction in progress")
+	errTxInProgress  = errors.New("a transaction is already in progress")
+	errTxDone        = errors.New("transaction has already been committed or rolled back")
+)
+
+// Tx is a transaction. It reads from a snapshot of the database taken when it
+// began, together with its own writes. The writes are buffered until Commit and
+// aren't checked against the transactions that committed in the meantime, the
+// last one to commit wins.
+type Tx struct {
+	db       *DB
+	executor *exec
+	storage  txStorage
+	done     bool
+}
+
+// Begin starts a transaction. It is independent of the transaction of a BEGIN
+// statement given to Execute.
+func (d *DB) Begin() (*Tx, error) {
+	return d.begin(txReadWrite)
+}
+
+// BeginReadOnly starts a transaction that can't write. All of its queries read
+// the same snapshot of the database.
+func (d *DB) BeginReadOnly() (*Tx, error) {
+	return d.begin(txReadOnly)
+}
+
+func (d *DB) begin(mode int) (*Tx, error) {
+	storage, err := d.executor.storage.begin(mode)
 	if err != nil {
 		return nil, err
 	}
 
-	return d.executor.execute(root)
+	return &Tx{
+		db: d,
+		executor: &exec{
+			storage:      storage,
+			memoryBudget: d.executor.memoryBudget,
+			gcTTL:        d.executor.gcTTL,
+		},
+		storage: storage,
+	}, nil
+}
+
+// Execute runs a statement in the transaction. COMMIT and ROLLBACK end it like
+// Commit and Rollback do. The writes of a statement that fails are discarded,
+// the transaction keeps the writes of the statements before it.
+func (tx *Tx) Execute(query string) (*QueryResponse, error) {
+	if tx.done {
+		return nil, errTxDone
+	}
+
+	root, err := tx.db.parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if tn, ok := root.(*transactionNode); ok {
+		switch tn.action {
+		case commitToken:
+			err = tx.Commit()
+		case rollbackToken:
+			err = tx.Rollback()
+		default:
+			err = errTxInProgress
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &QueryResponse{empty: true}, nil
+	}
+
+	storage, err := tx.storage.begin(txStatement)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := *tx.executor
+	stmt.storage = storage
+	res, err := stmt.execute(root)
+	if err != nil {
+		storage.rollback()
+		return nil, err
+	}
+
+	if err := storage.commit(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Commit writes everything the transaction wrote to the database at once and
+// releases its snapshot.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return errTxDone
+	}
+
+	tx.done = true
+	return tx.storage.commit()
+}
+
+// Rollback discards the writes of the transaction.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return errTxDone
+	}
+
+	tx.done = true
+	tx.storage.rollback()
+	return nil
 }
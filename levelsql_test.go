@@ -4,7 +4,13 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 // Benchmarks
@@ -65,6 +71,11 @@ func BenchmarkSelect(b *testing.B) {
 	}
 }
 
+// queryer is a DB or a Tx.
+type queryer interface {
+	Execute(query string) (*QueryResponse, error)
+}
+
 func setupTestDB(t *testing.T) (*DB, func()) {
 	dbPath := fmt.Sprintf("test_db_%d", rand.Int31())
 	db, err := OpenDB(dbPath)
@@ -166,6 +177,645 @@ func TestMultipleInsertAndSelect(t *testing.T) {
 	}
 }
 
+func TestInsertColumns(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE t (c1 INTEGER, c2 STRING)",
+		"ALTER TABLE t ADD COLUMN c3 INTEGER DEFAULT 7",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	result, err := db.Execute("INSERT INTO t (c3, c1) VALUES (1, 10), (2, 20), (3, 1 + 29)")
+	if err != nil {
+		t.Fatalf("Failed to insert rows: %v", err)
+	}
+
+	if result.RowsAffected() != 3 {
+		t.Fatalf("Expected 3 affected rows, got %d", result.RowsAffected())
+	}
+
+	for _, q := range []string{
+		"INSERT INTO t (c2) VALUES ('x')",
+		"INSERT INTO t VALUES (50, 'y')",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	result, err = db.Execute("SELECT c1, c2, c3 FROM t ORDER BY c1 NULLS FIRST")
+	if err != nil {
+		t.Fatalf("Failed to select: %v", err)
+	}
+
+	want := [][]string{{"", "x", "7"}, {"10", "", "1"}, {"20", "", "2"}, {"30", "", "3"}, {"50", "y", "7"}}
+	if !reflect.DeepEqual(result.rows, want) {
+		t.Fatalf("Expected %v, got %v", want, result.rows)
+	}
+
+	for _, q := range []string{
+		"INSERT INTO t (c1, missing) VALUES (1, 2)",
+		"INSERT INTO t (c1, c1) VALUES (1, 2)",
+		"INSERT INTO t (c1, c2) VALUES (1)",
+		"INSERT INTO t VALUES (1, 'a', 2, 3)",
+		"INSERT INTO t (c1) VALUES (60), (1 / 0)",
+	} {
+		if _, err := db.Execute(q); err == nil {
+			t.Errorf("Expected an error for %q", q)
+		}
+	}
+
+	// a failing row leaves out the whole statement
+	result, err = db.Execute("SELECT COUNT(*) FROM t")
+	if err != nil {
+		t.Fatalf("Failed to select: %v", err)
+	}
+
+	if result.rows[0][0] != "5" {
+		t.Fatalf("Expected 5 rows, got %s", result.rows[0][0])
+	}
+}
+
+func TestInsertSelect(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// more rows than fit into a single batch
+	var values []string
+	for i := 0; i < 2*insertBatchSize+10; i++ {
+		values = append(values, fmt.Sprintf("(%d, 'user_%d')", i, i))
+	}
+
+	for _, q := range []string{
+		"CREATE TABLE users (id INTEGER, name STRING)",
+		"CREATE TABLE copy (id INTEGER, name STRING, tag STRING)",
+		"CREATE TABLE even (id INTEGER)",
+		"INSERT INTO users VALUES " + strings.Join(values, ", "),
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		insert   string
+		affected int
+		query    string
+		want     [][]string
+	}{
+		{"INSERT INTO copy SELECT *, 'all' FROM users", len(values), "SELECT COUNT(*), MIN(tag) FROM copy", [][]string{{"2010", "all"}}},
+		{"INSERT INTO even (id) SELECT id FROM users WHERE id % 2 = 0 AND id < 10", 5, "SELECT id FROM even ORDER BY id", [][]string{{"0"}, {"2"}, {"4"}, {"6"}, {"8"}}},
+		{"INSERT INTO even SELECT id * 100 FROM even ORDER BY id DESC LIMIT 2", 2, "SELECT id FROM even ORDER BY id DESC LIMIT 3", [][]string{{"800"}, {"600"}, {"8"}}},
+		{"INSERT INTO copy (name, id) SELECT upper(name), COUNT(*) FROM users WHERE id < 3 GROUP BY name", 3, "SELECT id, name, tag FROM copy WHERE tag IS NULL ORDER BY name", [][]string{{"1", "USER_0", ""}, {"1", "USER_1", ""}, {"1", "USER_2", ""}}},
+		{"INSERT INTO even SELECT 1 WHERE 1 = 0", 0, "SELECT COUNT(*) FROM even", [][]string{{"7"}}},
+		{"INSERT INTO even SELECT id FROM users LIMIT 0", 0, "SELECT COUNT(*) FROM even", [][]string{{"7"}}},
+	}
+
+	for _, tt := range tests {
+		result, err := db.Execute(tt.insert)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.insert, err)
+		}
+
+		if result.RowsAffected() != tt.affected {
+			t.Errorf("%q: expected %d affected rows, got %d", tt.insert, tt.affected, result.RowsAffected())
+		}
+
+		result, err = db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+
+		if !reflect.DeepEqual(result.rows, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, result.rows)
+		}
+	}
+
+	for _, q := range []string{
+		"INSERT INTO even SELECT id, name FROM users",
+		"INSERT INTO even (id) SELECT * FROM copy",
+		"INSERT INTO missing SELECT id FROM users",
+		"INSERT INTO even SELECT id FROM missing",
+	} {
+		if _, err := db.Execute(q); err == nil {
+			t.Errorf("Expected an error for %q", q)
+		}
+	}
+
+	// the first duplicate comes after a full batch, which isn't kept either
+	if _, err := db.Execute("CREATE TABLE keyed (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	q := fmt.Sprintf("INSERT INTO keyed SELECT id %% %d FROM users ORDER BY id", insertBatchSize+5)
+	if _, err := db.Execute(q); err == nil {
+		t.Fatalf("Expected a duplicate key error for %q", q)
+	}
+
+	result, err := db.Execute("SELECT COUNT(*) FROM keyed")
+	if err != nil {
+		t.Fatalf("Failed to count: %v", err)
+	}
+	if !reflect.DeepEqual(result.rows, [][]string{{"0"}}) {
+		t.Errorf("Expected a failed statement to write nothing, got %v rows", result.rows)
+	}
+
+	// in a transaction the failed statement is dropped and the ones before it stay
+	for _, q := range []string{"BEGIN", "INSERT INTO keyed VALUES (-1)"} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+	if _, err := db.Execute(q); err == nil {
+		t.Fatalf("Expected a duplicate key error for %q in a transaction", q)
+	}
+	if _, err := db.Execute("COMMIT"); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	result, err = db.Execute("SELECT COUNT(*) FROM keyed")
+	if err != nil {
+		t.Fatalf("Failed to count: %v", err)
+	}
+	if !reflect.DeepEqual(result.rows, [][]string{{"1"}}) {
+		t.Errorf("Expected only the row of the statement that succeeded, got %v rows", result.rows)
+	}
+}
+
+func TestPrimaryKey(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name STRING)",
+		"CREATE TABLE orders (uid INTEGER, seq INTEGER, item STRING, PRIMARY KEY (uid, seq))",
+		"INSERT INTO users VALUES (10, 'Carol'), (-5, 'Alice'), (2, 'Bob')",
+		"INSERT INTO orders VALUES (10, 2, 'pen'), (2, 1, 'book'), (10, 1, 'lamp')",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		// rows come back in key order without an ORDER BY
+		{"SELECT id, name FROM users", [][]string{{"-5", "Alice"}, {"2", "Bob"}, {"10", "Carol"}}},
+		{"SELECT uid, seq, item FROM orders", [][]string{{"2", "1", "book"}, {"10", "1", "lamp"}, {"10", "2", "pen"}}},
+		{"SELECT name FROM users WHERE id = 2", [][]string{{"Bob"}}},
+		{"SELECT name FROM users u WHERE u.id >= 2", [][]string{{"Bob"}, {"Carol"}}},
+		{"SELECT item FROM orders WHERE uid = 10 AND seq = 2", [][]string{{"pen"}}},
+		{"SELECT u.name, o.item FROM users u JOIN orders o ON u.id = o.uid", [][]string{{"Bob", "book"}, {"Carol", "lamp"}, {"Carol", "pen"}}},
+	}
+
+	check := func() {
+		for _, tt := range tests {
+			result, err := db.Execute(tt.query)
+			if err != nil {
+				t.Fatalf("Failed to execute %q: %v", tt.query, err)
+			}
+
+			if !reflect.DeepEqual(result.rows, tt.want) {
+				t.Errorf("%q: expected %v, got %v", tt.query, tt.want, result.rows)
+			}
+		}
+	}
+	check()
+
+	// none of the failing statements may change the tables
+	for _, q := range []string{
+		"INSERT INTO users VALUES (2, 'Dave')",
+		"INSERT INTO users VALUES (3, 'Dave'), (3, 'Erin')",
+		"INSERT INTO users VALUES (NULL, 'Dave')",
+		"INSERT INTO users (name) VALUES ('Dave')",
+		"INSERT INTO orders VALUES (10, 1, 'cup')",
+		"INSERT INTO users SELECT id, name FROM users",
+		"UPDATE users SET id = 10 WHERE id = 2",
+		"UPDATE users SET id = 1",
+		"UPDATE users SET id = NULL WHERE id = 2",
+		"ALTER TABLE users DROP COLUMN id",
+		"CREATE TABLE bad (a INTEGER, PRIMARY KEY (b))",
+		"CREATE TABLE bad (a INTEGER, PRIMARY KEY (a, a))",
+	} {
+		if _, err := db.Execute(q); err == nil {
+			t.Errorf("Expected an error for %q", q)
+		}
+	}
+	check()
+
+	for _, q := range []string{
+		"UPDATE users SET id = 20, name = 'Carl' WHERE id = 10",
+		"UPDATE users SET name = 'Bobby' WHERE id = 2",
+		"UPDATE orders SET uid = 20 WHERE uid = 10",
+		"DELETE FROM users WHERE id < 0",
+		"INSERT INTO users VALUES (-5, 'Alex')",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests = []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT id, name FROM users", [][]string{{"-5", "Alex"}, {"2", "Bobby"}, {"20", "Carl"}}},
+		{"SELECT name FROM users WHERE id = 10", nil},
+		{"SELECT uid, seq, item FROM orders WHERE uid = 20", [][]string{{"20", "1", "lamp"}, {"20", "2", "pen"}}},
+		{"SELECT COUNT(*) FROM orders", [][]string{{"3"}}},
+	}
+	check()
+}
+
+func TestIndexes(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE users (id INTEGER, email STRING, age INTEGER)",
+		"INSERT INTO users VALUES (1, 'a@x', 30), (2, 'b@x', 25), (3, 'c@x', 30), (4, NULL, 40), (5, NULL, 25)",
+		// existing rows are indexed when the index is created
+		"CREATE UNIQUE INDEX users_email ON users (email)",
+		"CREATE INDEX users_age ON users (age) INCLUDE (id)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT id FROM users WHERE email = 'b@x'", [][]string{{"2"}}},
+		{"SELECT id, age FROM users WHERE age = 30 ORDER BY id", [][]string{{"1", "30"}, {"3", "30"}}},
+		{"SELECT id FROM users WHERE age >= 30 AND age < 40 ORDER BY id", [][]string{{"1"}, {"3"}}},
+		{"SELECT email FROM users u WHERE u.age = 25 ORDER BY u.id", [][]string{{"b@x"}, {""}}},
+		{"SELECT COUNT(*) FROM users WHERE age BETWEEN 25 AND 30", [][]string{{"4"}}},
+	}
+
+	check := func() {
+		for _, tt := range tests {
+			result, err := db.Execute(tt.query)
+			if err != nil {
+				t.Fatalf("Failed to execute %q: %v", tt.query, err)
+			}
+
+			if !reflect.DeepEqual(result.rows, tt.want) {
+				t.Errorf("%q: expected %v, got %v", tt.query, tt.want, result.rows)
+			}
+		}
+	}
+	check()
+
+	// none of the failing statements may change the table or its indexes
+	for _, q := range []string{
+		"INSERT INTO users VALUES (6, 'a@x', 50)",
+		"INSERT INTO users VALUES (6, 'f@x', 50), (7, 'f@x', 50)",
+		"UPDATE users SET email = 'c@x' WHERE id = 1",
+		"CREATE INDEX users_age ON users (id)",
+		"CREATE INDEX bad ON missing (id)",
+		"CREATE INDEX bad ON users (nope)",
+		"CREATE INDEX bad ON users (age) INCLUDE (age)",
+		"CREATE UNIQUE INDEX bad ON users (age)",
+		"ALTER TABLE users DROP COLUMN email",
+		"DROP INDEX bad",
+	} {
+		if _, err := db.Execute(q); err == nil {
+			t.Errorf("Expected an error for %q", q)
+		}
+	}
+	check()
+
+	for _, q := range []string{
+		"INSERT INTO users VALUES (6, NULL, 30)",
+		"UPDATE users SET email = 'd@x', age = 25 WHERE id = 4",
+		"UPDATE users SET email = 'c@x', age = 50 WHERE id = 3",
+		"DELETE FROM users WHERE email = 'a@x'",
+		"INSERT INTO users VALUES (7, 'a@x', 30)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests = []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT id FROM users WHERE email = 'a@x'", [][]string{{"7"}}},
+		{"SELECT id FROM users WHERE email = 'd@x'", [][]string{{"4"}}},
+		{"SELECT id FROM users WHERE age = 30 ORDER BY id", [][]string{{"6"}, {"7"}}},
+		{"SELECT id FROM users WHERE age = 25 ORDER BY id", [][]string{{"2"}, {"4"}, {"5"}}},
+		{"SELECT id FROM users WHERE age > 40", [][]string{{"3"}}},
+	}
+	check()
+
+	// the entries stay with the rows when the table is renamed
+	if _, err := db.Execute("ALTER TABLE users RENAME TO people"); err != nil {
+		t.Fatalf("Failed to rename table: %v", err)
+	}
+
+	result, err := db.Execute("SELECT id FROM people WHERE email = 'd@x'")
+	if err != nil {
+		t.Fatalf("Failed to select: %v", err)
+	}
+	if !reflect.DeepEqual(result.rows, [][]string{{"4"}}) {
+		t.Errorf("Expected [[4]] after rename, got %v", result.rows)
+	}
+
+	for _, q := range []string{
+		"DROP INDEX users_email",
+		"DROP INDEX IF EXISTS users_email",
+		"INSERT INTO people VALUES (8, 'a@x', 20)",
+		"CREATE INDEX users_email ON people (email)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	result, err = db.Execute("SELECT id FROM people WHERE email = 'a@x' ORDER BY id")
+	if err != nil {
+		t.Fatalf("Failed to select: %v", err)
+	}
+	if !reflect.DeepEqual(result.rows, [][]string{{"7"}, {"8"}}) {
+		t.Errorf("Expected [[7] [8]] after dropping the unique index, got %v", result.rows)
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	exec := func(queries ...string) {
+		t.Helper()
+		for _, q := range queries {
+			if _, err := db.Execute(q); err != nil {
+				t.Fatalf("Failed to execute %q: %v", q, err)
+			}
+		}
+	}
+
+	query := func(e queryer, q string, want [][]string) {
+		t.Helper()
+		result, err := e.Execute(q)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+		if !reflect.DeepEqual(result.rows, want) {
+			t.Errorf("%q: expected %v, got %v", q, want, result.rows)
+		}
+	}
+
+	exec(
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name STRING)",
+		"CREATE UNIQUE INDEX users_name ON users (name)",
+		"INSERT INTO users VALUES (1, 'Alice'), (2, 'Bob')",
+	)
+
+	// the transaction reads its own writes and a rollback drops them all
+	exec(
+		"BEGIN",
+		"INSERT INTO users VALUES (3, 'Carol')",
+		"UPDATE users SET name = 'Bobby' WHERE id = 2",
+		"DELETE FROM users WHERE id = 1",
+		"CREATE TABLE orders (uid INTEGER, item STRING)",
+		"INSERT INTO orders SELECT id, name FROM users",
+	)
+	query(db, "SELECT id, name FROM users", [][]string{{"2", "Bobby"}, {"3", "Carol"}})
+	query(db, "SELECT id FROM users WHERE name = 'Carol'", [][]string{{"3"}})
+	query(db, "SELECT COUNT(*) FROM orders", [][]string{{"2"}})
+	if _, err := db.Execute("INSERT INTO users VALUES (4, 'Carol')"); err == nil {
+		t.Errorf("Expected a unique index error inside the transaction")
+	}
+	if _, err := db.Execute("BEGIN"); err == nil {
+		t.Errorf("Expected an error for a nested BEGIN")
+	}
+	exec("ROLLBACK")
+
+	query(db, "SELECT id, name FROM users", [][]string{{"1", "Alice"}, {"2", "Bob"}})
+	if _, err := db.Execute("SELECT * FROM orders"); err == nil {
+		t.Errorf("Expected the table created in the rolled back transaction to be gone")
+	}
+
+	for _, q := range []string{"COMMIT", "ROLLBACK"} {
+		if _, err := db.Execute(q); err == nil {
+			t.Errorf("Expected an error for %q without a transaction", q)
+		}
+	}
+
+	exec(
+		"BEGIN TRANSACTION",
+		"INSERT INTO users SELECT id + 10, name || '2' FROM users",
+		"TRUNCATE TABLE users",
+		"INSERT INTO users VALUES (5, 'Erin')",
+		"COMMIT",
+	)
+	query(db, "SELECT id, name FROM users", [][]string{{"5", "Erin"}})
+	query(db, "SELECT id FROM users WHERE name = 'Alice'", nil)
+
+	// a transaction from Begin is only seen by others once it commits
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	if _, err := tx.Execute("INSERT INTO users VALUES (6, 'Frank')"); err != nil {
+		t.Fatalf("Failed to insert in transaction: %v", err)
+	}
+	query(tx, "SELECT name FROM users WHERE id = 6", [][]string{{"Frank"}})
+	query(db, "SELECT name FROM users WHERE id = 6", nil)
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	query(db, "SELECT name FROM users WHERE id = 6", [][]string{{"Frank"}})
+
+	if _, err := tx.Execute("SELECT name FROM users"); err == nil {
+		t.Errorf("Expected an error executing in a finished transaction")
+	}
+	if err := tx.Rollback(); err == nil {
+		t.Errorf("Expected an error rolling back a committed transaction")
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	if _, err := tx.Execute("DELETE FROM users"); err != nil {
+		t.Fatalf("Failed to delete in transaction: %v", err)
+	}
+	if _, err := tx.Execute("ROLLBACK"); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+	query(db, "SELECT COUNT(*) FROM users", [][]string{{"2"}})
+}
+
+func TestSnapshots(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name STRING)",
+		"INSERT INTO users VALUES (1, 'Alice'), (2, 'Bob')",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	count := func(e queryer, want string) {
+		t.Helper()
+		result, err := e.Execute("SELECT COUNT(*) FROM users")
+		if err != nil {
+			t.Fatalf("Failed to count: %v", err)
+		}
+		if !reflect.DeepEqual(result.rows, [][]string{{want}}) {
+			t.Errorf("Expected %s rows, got %v", want, result.rows)
+		}
+	}
+
+	ro, err := db.BeginReadOnly()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	rw, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+
+	if _, err := db.Execute("INSERT INTO users VALUES (3, 'Carol')"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	// both transactions keep reading the database as of when they began
+	count(db, "3")
+	count(ro, "2")
+	count(rw, "2")
+
+	for _, q := range []string{
+		"INSERT INTO users VALUES (4, 'Dave')",
+		"UPDATE users SET name = 'Al' WHERE id = 1",
+		"DELETE FROM users",
+		"CREATE TABLE other (id INTEGER)",
+		"DROP TABLE users",
+	} {
+		if _, err := ro.Execute(q); err == nil {
+			t.Errorf("Expected %q to fail in a read only transaction", q)
+		}
+	}
+	count(ro, "2")
+
+	if err := ro.Commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if err := rw.Rollback(); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+
+	if _, err := db.Execute("BEGIN READ ONLY"); err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	count(db, "3")
+	if _, err := db.Execute("INSERT INTO users VALUES (4, 'Dave')"); err == nil {
+		t.Errorf("Expected an insert to fail in a read only transaction")
+	}
+	if _, err := db.Execute("COMMIT"); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+
+	if _, err := db.Execute("INSERT INTO users VALUES (4, 'Dave')"); err != nil {
+		t.Fatalf("Failed to insert after the transaction: %v", err)
+	}
+	count(db, "4")
+}
+
+func TestAsOfSystemTime(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name STRING)",
+		"CREATE INDEX users_name ON users (name)",
+		"INSERT INTO users VALUES (1, 'Alice'), (2, 'Bob')",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	before := time.Now()
+	for _, q := range []string{
+		"UPDATE users SET name = 'Al' WHERE id = 1",
+		"DELETE FROM users WHERE id = 2",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	at := fmt.Sprintf("'%s'", before.Format(time.RFC3339Nano))
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT id, name FROM users ORDER BY id", [][]string{{"1", "Al"}}},
+		{"SELECT id, name FROM users AS OF SYSTEM TIME " + at + " ORDER BY id", [][]string{{"1", "Alice"}, {"2", "Bob"}}},
+		{fmt.Sprintf("SELECT name FROM users AS OF SYSTEM TIME %d WHERE id = 2", before.UnixNano()), [][]string{{"Bob"}}},
+		// the index only has the newest rows, so it isn't used
+		{"SELECT id FROM users AS OF SYSTEM TIME " + at + " WHERE name = 'Alice'", [][]string{{"1"}}},
+		{"SELECT u.name, o.name FROM users u JOIN users o ON u.id = o.id AS OF SYSTEM TIME " + at + " ORDER BY u.id", [][]string{{"Alice", "Alice"}, {"Bob", "Bob"}}},
+		{"SELECT COUNT(*) FROM users AS OF SYSTEM TIME '-1h'", [][]string{{"0"}}},
+	}
+
+	for _, tc := range tests {
+		result, err := db.Execute(tc.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tc.query, err)
+		}
+		if !reflect.DeepEqual(result.rows, tc.want) {
+			t.Errorf("%q: expected %v, got %v", tc.query, tc.want, result.rows)
+		}
+	}
+
+	for _, q := range []string{
+		"SELECT * FROM users AS OF SYSTEM TIME '10s'",
+		"SELECT * FROM users AS OF SYSTEM TIME '-26h'",
+		"SELECT * FROM users AS OF SYSTEM TIME 'yesterday'",
+	} {
+		if _, err := db.Execute(q); err == nil {
+			t.Errorf("Expected %q to fail", q)
+		}
+	}
+
+	// the old version of Alice and both versions of Bob are gone
+	db.SetGCTTL(time.Nanosecond)
+	removed, err := db.GC()
+	if err != nil {
+		t.Fatalf("Failed to collect garbage: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("Expected 3 versions to be removed, got %d", removed)
+	}
+
+	result, err := db.Execute("SELECT id, name FROM users")
+	if err != nil {
+		t.Fatalf("Failed to select: %v", err)
+	}
+	if !reflect.DeepEqual(result.rows, [][]string{{"1", "Al"}}) {
+		t.Errorf("Expected [[1 Al]] after GC, got %v", result.rows)
+	}
+	if _, err := db.Execute("SELECT * FROM users AS OF SYSTEM TIME " + at); err == nil {
+		t.Errorf("Expected a read older than the GC TTL to fail")
+	}
+}
+
 func TestFunctionInSelect(t *testing.T) {
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
@@ -193,3 +843,1011 @@ func TestFunctionInSelect(t *testing.T) {
 		t.Fatalf("Unexpected result: %v", result.rows[0])
 	}
 }
+
+func TestOperators(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE nums (a INTEGER, b INTEGER, s STRING)",
+		"INSERT INTO nums VALUES (7, 2, 'x')",
+		"INSERT INTO nums VALUES (3, 5, 'y')",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT a + b * 2, a - b - 1, a / b, a % b, -a, s || '-' || a FROM nums WHERE a = 7", [][]string{
+			{"11", "4", "3", "1", "-7", "x-7"},
+		}},
+		{"SELECT s FROM nums WHERE a + 1 = 8", [][]string{{"x"}}},
+		{"SELECT s FROM nums WHERE a <> 7", [][]string{{"y"}}},
+		{"SELECT s FROM nums WHERE a != 3", [][]string{{"x"}}},
+		{"SELECT s FROM nums WHERE b > a", [][]string{{"y"}}},
+		{"SELECT s FROM nums WHERE a >= 7", [][]string{{"x"}}},
+		{"SELECT s FROM nums WHERE a <= 3", [][]string{{"y"}}},
+		{"SELECT s FROM nums WHERE s < 'y'", [][]string{{"x"}}},
+		{"SELECT s FROM nums ORDER BY a * -1", [][]string{{"x"}, {"y"}}},
+	}
+
+	for _, tt := range tests {
+		result, err := db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+
+		if !reflect.DeepEqual(result.rows, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, result.rows)
+		}
+	}
+
+	for _, q := range []string{
+		"SELECT a / 0 FROM nums",
+		"SELECT s + 1 FROM nums",
+		"SELECT -s FROM nums",
+	} {
+		if _, err := db.Execute(q); err == nil {
+			t.Errorf("Expected an error for %q", q)
+		}
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE t (a INTEGER, b INTEGER, c STRING)",
+		"INSERT INTO t VALUES (1, 3, 'x')",
+		"INSERT INTO t VALUES (1, 7, 'y')",
+		"INSERT INTO t VALUES (2, 9, 'x')",
+		// rows written before the column was added read it as NULL
+		"ALTER TABLE t ADD COLUMN n INTEGER",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT b FROM t WHERE a = 1 AND (b < 5 OR c = 'x') ORDER BY b", [][]string{{"3"}}},
+		{"SELECT b FROM t WHERE a = 1 AND b < 5 OR c = 'x' ORDER BY b", [][]string{{"3"}, {"9"}}},
+		{"SELECT b FROM t WHERE NOT a = 1 ORDER BY b", [][]string{{"9"}}},
+		{"SELECT b FROM t WHERE NOT (a = 1 OR b = 9) ORDER BY b", nil},
+		// NULL is unknown, which AND and OR only resolve when the other side
+		// decides the result.
+		{"SELECT b FROM t WHERE n OR b = 3 ORDER BY b", [][]string{{"3"}}},
+		{"SELECT b FROM t WHERE NOT (n AND b = 3) ORDER BY b", [][]string{{"7"}, {"9"}}},
+		{"SELECT b FROM t WHERE NOT n ORDER BY b", nil},
+		{"SELECT n AND 0, n OR 1, n AND 1, NOT n FROM t WHERE b = 3", [][]string{{"false", "true", "", ""}}},
+	}
+
+	for _, tt := range tests {
+		result, err := db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+
+		if !reflect.DeepEqual(result.rows, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, result.rows)
+		}
+	}
+}
+
+func TestNullSemantics(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE t (id INTEGER, n INTEGER, s STRING)",
+		"INSERT INTO t VALUES (1, 10, 'a')",
+		"INSERT INTO t VALUES (2, NULL, 'b')",
+		"INSERT INTO t VALUES (3, NULL, NULL)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT id FROM t WHERE n IS NULL ORDER BY id", [][]string{{"2"}, {"3"}}},
+		{"SELECT id FROM t WHERE n IS NOT NULL", [][]string{{"1"}}},
+		{"SELECT id FROM t WHERE n = NULL", nil},
+		{"SELECT id FROM t WHERE NULL = NULL", nil},
+		{"SELECT id FROM t WHERE n <> 10", nil},
+		{"SELECT id FROM t WHERE NOT (n = 10)", nil},
+		{"SELECT id FROM t WHERE n = 'x' OR s = 'b'", [][]string{{"2"}}},
+		{"SELECT id FROM t WHERE n IS NOT DISTINCT FROM NULL AND s IS DISTINCT FROM 'b'", [][]string{{"3"}}},
+		{"SELECT id FROM t WHERE n IS DISTINCT FROM 10 ORDER BY id", [][]string{{"2"}, {"3"}}},
+		{"SELECT n + 1 IS NULL, -n IS NULL, s || 'x' IS NULL, upper(s) IS NULL, concat(s, 'x') IS NULL FROM t WHERE id = 3", [][]string{
+			{"true", "true", "true", "true", "true"},
+		}},
+		{"SELECT n = NULL, n IS 10 FROM t WHERE id = 1", [][]string{{"", "true"}}},
+		{"SELECT COUNT(n), COUNT(*) FROM t", [][]string{{"1", "3"}}},
+	}
+
+	for _, tt := range tests {
+		result, err := db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+
+		if !reflect.DeepEqual(result.rows, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, result.rows)
+		}
+	}
+}
+
+func TestPredicates(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE files (id INTEGER, name STRING, size INTEGER)",
+		"INSERT INTO files VALUES (1, 'main.go', 120)",
+		"INSERT INTO files VALUES (2, 'Makefile', 40)",
+		"INSERT INTO files VALUES (3, 'README.md', 900)",
+		"INSERT INTO files VALUES (4, '100%.txt', NULL)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT id FROM files WHERE id IN (1, 3, NULL) ORDER BY id", [][]string{{"1"}, {"3"}}},
+		{"SELECT id FROM files WHERE id NOT IN (1, 3) ORDER BY id", [][]string{{"2"}, {"4"}}},
+		{"SELECT id FROM files WHERE id NOT IN (1, NULL)", nil},
+		{"SELECT id FROM files WHERE name IN ('main.go', 1)", [][]string{{"1"}}},
+		{"SELECT id FROM files WHERE size BETWEEN 40 AND 120 ORDER BY id", [][]string{{"1"}, {"2"}}},
+		{"SELECT id FROM files WHERE size NOT BETWEEN 40 AND 120", [][]string{{"3"}}},
+		{"SELECT id FROM files WHERE id BETWEEN 2 AND NULL", nil},
+		{"SELECT id FROM files WHERE id NOT BETWEEN 2 AND NULL", [][]string{{"1"}}},
+		{"SELECT id FROM files WHERE name LIKE 'M%' ORDER BY id", [][]string{{"2"}}},
+		{"SELECT id FROM files WHERE name ILIKE 'm%' ORDER BY id", [][]string{{"1"}, {"2"}}},
+		{"SELECT id FROM files WHERE name NOT LIKE '%.%' ORDER BY id", [][]string{{"2"}}},
+		{"SELECT id FROM files WHERE name LIKE '____.go'", [][]string{{"1"}}},
+		{"SELECT id FROM files WHERE name LIKE '%!%%' ESCAPE '!'", [][]string{{"4"}}},
+		{"SELECT id FROM files WHERE name GLOB '*.[gm]?' ORDER BY id", [][]string{{"1"}, {"3"}}},
+		{"SELECT id FROM files WHERE name GLOB 'm*'", [][]string{{"1"}}},
+		{"SELECT name LIKE NULL, NULL IN (1), size IN (1) FROM files WHERE id = 4", [][]string{{"", "", ""}}},
+	}
+
+	for _, tt := range tests {
+		result, err := db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+
+		if !reflect.DeepEqual(result.rows, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, result.rows)
+		}
+	}
+
+	if _, err := db.Execute("SELECT id FROM files WHERE name LIKE 'a' ESCAPE '!!'"); err == nil {
+		t.Errorf("Expected an error for a multi-character escape")
+	}
+}
+
+func TestConditionals(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE t (id INTEGER, a INTEGER, b INTEGER, s STRING)",
+		"INSERT INTO t VALUES (1, 10, 2, 'x')",
+		"INSERT INTO t VALUES (2, 10, 0, NULL)",
+		"INSERT INTO t VALUES (3, NULL, 5, 'z')",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		// the division is only evaluated when it is picked, so b = 0 never fails.
+		{"SELECT CASE WHEN b = 0 THEN -1 ELSE a / b END FROM t ORDER BY id", [][]string{{"5"}, {"-1"}, {""}}},
+		{"SELECT IIF(b = 0, -1, a / b) FROM t ORDER BY id", [][]string{{"5"}, {"-1"}, {""}}},
+		{"SELECT COALESCE(s, 'none'), COALESCE(a, b, 1 / 0), COALESCE(NULL) FROM t ORDER BY id", [][]string{
+			{"x", "10", ""}, {"none", "10", ""}, {"z", "5", ""},
+		}},
+		{"SELECT CASE s WHEN 'x' THEN 'first' WHEN NULL THEN 'null' ELSE 'other' END FROM t ORDER BY id", [][]string{
+			{"first"}, {"other"}, {"other"},
+		}},
+		{"SELECT CASE WHEN a IS NULL THEN 'missing' END FROM t ORDER BY id", [][]string{{""}, {""}, {"missing"}}},
+		{"SELECT NULLIF(b, 0), NULLIF(s, NULL) FROM t ORDER BY id", [][]string{{"2", "x"}, {"", ""}, {"5", "z"}}},
+		{"SELECT id FROM t WHERE CASE WHEN b = 0 THEN 0 ELSE a / b = 5 END", [][]string{{"1"}}},
+		{"SELECT SUM(CASE WHEN b > 1 THEN 1 ELSE 0 END) FROM t", [][]string{{"2"}}},
+	}
+
+	for _, tt := range tests {
+		result, err := db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+
+		if !reflect.DeepEqual(result.rows, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, result.rows)
+		}
+	}
+
+	for _, q := range []string{
+		"SELECT CASE WHEN b = 0 THEN a / b END FROM t",
+		"SELECT IIF(1, 2) FROM t",
+		"SELECT NULLIF(a) FROM t",
+	} {
+		if _, err := db.Execute(q); err == nil {
+			t.Errorf("Expected an error for %q", q)
+		}
+	}
+}
+
+func TestAliases(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE users (id INTEGER, name STRING, age INTEGER)",
+		"INSERT INTO users VALUES (1, 'Alice', 30)",
+		"INSERT INTO users VALUES (2, 'Bob', 25)",
+		"INSERT INTO users VALUES (3, 'Carol', 30)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query  string
+		fields []string
+		rows   [][]string
+	}{
+		{"SELECT id, lower(name), age + 1 FROM users WHERE id = 1", []string{"id", "lower(name)", "age + 1"}, [][]string{{"1", "alice", "31"}}},
+		{"SELECT name AS who, age years FROM users WHERE id = 2", []string{"who", "years"}, [][]string{{"Bob", "25"}}},
+		{"SELECT u.name FROM users u WHERE u.id = 3", []string{"name"}, [][]string{{"Carol"}}},
+		{"SELECT -age AS neg, name FROM users ORDER BY neg, name", []string{"neg", "name"}, [][]string{
+			{"-30", "Alice"}, {"-30", "Carol"}, {"-25", "Bob"},
+		}},
+		{"SELECT age / 10 AS decade, COUNT(*) AS n FROM users GROUP BY decade ORDER BY decade", []string{"decade", "n"}, [][]string{
+			{"2", "1"}, {"3", "2"},
+		}},
+		{"SELECT COUNT(*) FROM users", []string{"COUNT(*)"}, [][]string{{"3"}}},
+		{"SELECT upper('x'), name || '!' FROM users WHERE id = 1", []string{"upper('x')", "name || '!'"}, [][]string{{"X", "Alice!"}}},
+	}
+
+	for _, tt := range tests {
+		result, err := db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+
+		if !reflect.DeepEqual(result.fields, tt.fields) {
+			t.Errorf("%q: expected fields %v, got %v", tt.query, tt.fields, result.fields)
+		}
+
+		if !reflect.DeepEqual(result.rows, tt.rows) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.rows, result.rows)
+		}
+	}
+}
+
+func TestStarExpansion(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE users (id INTEGER, name STRING, age INTEGER)",
+		"CREATE TABLE orders (id INTEGER, uid INTEGER, item STRING)",
+		"INSERT INTO users VALUES (1, 'Alice', 30)",
+		"INSERT INTO orders VALUES (7, 1, 'book')",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query  string
+		fields []string
+		rows   [][]string
+	}{
+		{"SELECT * FROM users", []string{"id", "name", "age"}, [][]string{{"1", "Alice", "30"}}},
+		{"SELECT * EXCLUDE (age) FROM users", []string{"id", "name"}, [][]string{{"1", "Alice"}}},
+		{"SELECT * EXCLUDE age REPLACE (upper(name) AS name), age FROM users", []string{"id", "name", "age"}, [][]string{{"1", "ALICE", "30"}}},
+		{"SELECT o.*, u.name FROM users u JOIN orders o ON u.id = o.uid", []string{"id", "uid", "item", "name"}, [][]string{{"7", "1", "book", "Alice"}}},
+		{"SELECT * EXCLUDE (o.id, uid) FROM users u JOIN orders o ON u.id = o.uid", []string{"id", "name", "age", "item"}, [][]string{{"1", "Alice", "30", "book"}}},
+		{"SELECT COUNT(*) FROM users", []string{"COUNT(*)"}, [][]string{{"1"}}},
+	}
+
+	for _, tt := range tests {
+		result, err := db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+
+		if !reflect.DeepEqual(result.fields, tt.fields) {
+			t.Errorf("%q: expected fields %v, got %v", tt.query, tt.fields, result.fields)
+		}
+
+		if !reflect.DeepEqual(result.rows, tt.rows) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.rows, result.rows)
+		}
+	}
+
+	for _, q := range []string{
+		"SELECT x.* FROM users",
+		"SELECT * EXCLUDE (missing) FROM users",
+		"SELECT * REPLACE (1 AS missing) FROM users",
+		"SELECT id FROM users WHERE * = 1",
+		"SELECT lower(*) FROM users",
+	} {
+		if _, err := db.Execute(q); err == nil {
+			t.Errorf("Expected an error for %q", q)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE users (id INTEGER, name STRING)",
+		"INSERT INTO users VALUES (1, 'Alice')",
+		"INSERT INTO users VALUES (2, 'Bob')",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query  string
+		fields []string
+		rows   [][]string
+	}{
+		{"SELECT 1 + 1, upper('x') AS u", []string{"1 + 1", "u"}, [][]string{{"2", "X"}}},
+		{"SELECT 1 WHERE 1 = 0", []string{"1"}, nil},
+		{"SELECT COUNT(*)", []string{"COUNT(*)"}, [][]string{{"1"}}},
+		{"VALUES (1, 'a'), (2, NULL)", []string{"column1", "column2"}, [][]string{{"1", "a"}, {"2", ""}}},
+		{"SELECT * FROM (VALUES (3, 'c'), (1, 'a')) AS v ORDER BY 1", []string{"column1", "column2"}, [][]string{{"1", "a"}, {"3", "c"}}},
+		{"SELECT v.tag, u.name FROM (VALUES (2, 'x'), (1, 'y'), (9, 'z')) v (id, tag) JOIN users u ON u.id = v.id ORDER BY u.id", []string{"tag", "name"}, [][]string{{"y", "Alice"}, {"x", "Bob"}}},
+		{"SELECT name, n FROM users CROSS JOIN (VALUES (10)) AS t (n) WHERE id = 2", []string{"name", "n"}, [][]string{{"Bob", "10"}}},
+	}
+
+	for _, tt := range tests {
+		result, err := db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+
+		if !reflect.DeepEqual(result.fields, tt.fields) {
+			t.Errorf("%q: expected fields %v, got %v", tt.query, tt.fields, result.fields)
+		}
+
+		if !reflect.DeepEqual(result.rows, tt.rows) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.rows, result.rows)
+		}
+	}
+
+	for _, q := range []string{
+		"SELECT *",
+		"VALUES (1), (1, 2)",
+		"VALUES (1 / 0)",
+	} {
+		if _, err := db.Execute(q); err == nil {
+			t.Errorf("Expected an error for %q", q)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, err := db.Execute("CREATE TABLE users (id INTEGER, name STRING, age INTEGER)")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	for _, q := range []string{
+		"INSERT INTO users VALUES (1, 'Alice', 30)",
+		"INSERT INTO users VALUES (2, 'Bob', 25)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to insert row: %v", err)
+		}
+	}
+
+	result, err := db.Execute("UPDATE users SET name = 'Carol', age = 40 WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+
+	if result.RowsAffected() != 1 || result.String() != "1 row affected" {
+		t.Fatalf("Unexpected update result: %s", result.String())
+	}
+
+	result, err = db.Execute("SELECT name, age FROM users WHERE id = 1")
+	if err != nil {
+		t.Fatalf("Failed to select: %v", err)
+	}
+
+	if len(result.rows) != 1 || result.rows[0][0] != "Carol" || result.rows[0][1] != "40" {
+		t.Fatalf("Unexpected result: %v", result.rows)
+	}
+
+	result, err = db.Execute("UPDATE users SET age = 1")
+	if err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+
+	if result.RowsAffected() != 2 {
+		t.Fatalf("Expected 2 affected rows, got %d", result.RowsAffected())
+	}
+
+	if _, err := db.Execute("UPDATE users SET missing = 1"); err == nil {
+		t.Fatalf("Expected error when updating unknown column")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, err := db.Execute("CREATE TABLE users (id INTEGER, name STRING, age INTEGER)")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	for _, q := range []string{
+		"INSERT INTO users VALUES (1, 'Alice', 30)",
+		"INSERT INTO users VALUES (2, 'Bob', 25)",
+		"INSERT INTO users VALUES (3, 'Carol', 30)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to insert row: %v", err)
+		}
+	}
+
+	result, err := db.Execute("DELETE FROM users WHERE age = 30")
+	if err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	if result.RowsAffected() != 2 {
+		t.Fatalf("Expected 2 affected rows, got %d", result.RowsAffected())
+	}
+
+	result, err = db.Execute("SELECT name FROM users")
+	if err != nil {
+		t.Fatalf("Failed to select: %v", err)
+	}
+
+	if len(result.rows) != 1 || result.rows[0][0] != "Bob" {
+		t.Fatalf("Unexpected result: %v", result.rows)
+	}
+
+	result, err = db.Execute("DELETE FROM users")
+	if err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	if result.RowsAffected() != 1 {
+		t.Fatalf("Expected 1 affected row, got %d", result.RowsAffected())
+	}
+
+	if _, err := db.Execute("DELETE FROM missing"); err == nil {
+		t.Fatalf("Expected error when deleting from unknown table")
+	}
+}
+
+func TestDropAndTruncateTable(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, err := db.Execute("CREATE TABLE users (id INTEGER, name STRING)")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := db.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d, 'user')", i)); err != nil {
+			t.Fatalf("Failed to insert row: %v", err)
+		}
+	}
+
+	if _, err := db.Execute("TRUNCATE TABLE users"); err != nil {
+		t.Fatalf("Failed to truncate table: %v", err)
+	}
+
+	result, err := db.Execute("SELECT id FROM users")
+	if err != nil {
+		t.Fatalf("Failed to select after truncate: %v", err)
+	}
+
+	if len(result.rows) != 0 {
+		t.Fatalf("Expected no rows after truncate, got %d", len(result.rows))
+	}
+
+	if _, err := db.Execute("INSERT INTO users VALUES (1, 'user')"); err != nil {
+		t.Fatalf("Failed to insert row: %v", err)
+	}
+
+	if _, err := db.Execute("DROP TABLE users"); err != nil {
+		t.Fatalf("Failed to drop table: %v", err)
+	}
+
+	if _, err := db.Execute("SELECT id FROM users"); err == nil {
+		t.Fatalf("Expected error when selecting from a dropped table")
+	}
+
+	if _, err := db.Execute("DROP TABLE users"); err == nil {
+		t.Fatalf("Expected error when dropping a missing table")
+	}
+
+	if _, err := db.Execute("DROP TABLE IF EXISTS users"); err != nil {
+		t.Fatalf("Unexpected error with IF EXISTS: %v", err)
+	}
+
+	// a table created with the same name must not see the old rows
+	if _, err := db.Execute("CREATE TABLE users (id INTEGER, name STRING)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	result, err = db.Execute("SELECT id FROM users")
+	if err != nil {
+		t.Fatalf("Failed to select: %v", err)
+	}
+
+	if len(result.rows) != 0 {
+		t.Fatalf("Expected no rows in recreated table, got %d", len(result.rows))
+	}
+
+	// the rows of users_archive don't belong to users
+	for _, q := range []string{
+		"CREATE TABLE users_archive (id INTEGER, name STRING)",
+		"CREATE INDEX users_archive_name ON users_archive (name)",
+		"INSERT INTO users VALUES (1, 'user')",
+		"INSERT INTO users_archive VALUES (2, 'old'), (3, 'older')",
+		"TRUNCATE TABLE users",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	result, err = db.Execute("SELECT COUNT(*) FROM users_archive WHERE name = 'old'")
+	if err != nil {
+		t.Fatalf("Failed to select: %v", err)
+	}
+	if !reflect.DeepEqual(result.rows, [][]string{{"1"}}) {
+		t.Fatalf("Expected the rows of users_archive to survive a truncate of users, got %v", result.rows)
+	}
+}
+
+func TestAlterTable(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE users (id INTEGER, name STRING)",
+		"INSERT INTO users VALUES (1, 'Alice')",
+		"ALTER TABLE users ADD COLUMN age INTEGER DEFAULT 20",
+		"INSERT INTO users VALUES (2, 'Bob', 30)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	expectRow := func(query string, want ...string) {
+		t.Helper()
+		result, err := db.Execute(query)
+		if err != nil {
+			t.Fatalf("Failed to select: %v", err)
+		}
+
+		if len(result.rows) != 1 || !reflect.DeepEqual(result.rows[0], want) {
+			t.Fatalf("Expected %v from %q, got %v", want, query, result.rows)
+		}
+	}
+
+	expectRow("SELECT name, age FROM users WHERE id = 1", "Alice", "20")
+	expectRow("SELECT name, age FROM users WHERE id = 2", "Bob", "30")
+
+	if _, err := db.Execute("ALTER TABLE users DROP COLUMN name"); err != nil {
+		t.Fatalf("Failed to drop column: %v", err)
+	}
+	expectRow("SELECT id, age FROM users WHERE id = 1", "1", "20")
+
+	if _, err := db.Execute("ALTER TABLE users RENAME COLUMN age TO years"); err != nil {
+		t.Fatalf("Failed to rename column: %v", err)
+	}
+	expectRow("SELECT years FROM users WHERE id = 2", "30")
+
+	// the old name cells are still stored in the rows but must not come back
+	if _, err := db.Execute("ALTER TABLE users ADD COLUMN name STRING"); err != nil {
+		t.Fatalf("Failed to add column: %v", err)
+	}
+	expectRow("SELECT id, name FROM users WHERE id = 1", "1", "")
+
+	if _, err := db.Execute("UPDATE users SET name = 'Carol' WHERE id = 1"); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	expectRow("SELECT years, name FROM users WHERE id = 1", "20", "Carol")
+
+	if _, err := db.Execute("ALTER TABLE users RENAME TO people"); err != nil {
+		t.Fatalf("Failed to rename table: %v", err)
+	}
+	expectRow("SELECT name FROM people WHERE id = 1", "Carol")
+
+	if _, err := db.Execute("SELECT name FROM users"); err == nil {
+		t.Fatalf("Expected error when selecting from the old table name")
+	}
+
+	// the rows stay under the key of the table, so a new table with the old name
+	// starts out empty
+	if _, err := db.Execute("CREATE TABLE users (id INTEGER)"); err != nil {
+		t.Fatalf("Failed to create table with the old name: %v", err)
+	}
+	expectRow("SELECT COUNT(*) FROM users", "0")
+	expectRow("SELECT COUNT(*) FROM people", "2")
+
+	if _, err := db.Execute("ALTER TABLE people DROP COLUMN missing"); err == nil {
+		t.Fatalf("Expected error when dropping an unknown column")
+	}
+
+	if _, err := db.Execute("CREATE TABLE people (id INTEGER)"); err == nil {
+		t.Fatalf("Expected error when creating an existing table")
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE users (id INTEGER, name STRING)",
+		"INSERT INTO users VALUES (10, 'Alice')",
+		"INSERT INTO users VALUES (9, 'Bob')",
+		"INSERT INTO users VALUES (2, 'Alice')",
+		"ALTER TABLE users ADD COLUMN age INTEGER",
+		"INSERT INTO users VALUES (5, 'Carol', 40)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"SELECT id FROM users ORDER BY id", []string{"2", "5", "9", "10"}},
+		{"SELECT id FROM users ORDER BY id DESC", []string{"10", "9", "5", "2"}},
+		{"SELECT id FROM users ORDER BY name, id DESC", []string{"10", "2", "9", "5"}},
+		{"SELECT id FROM users ORDER BY 1 DESC", []string{"10", "9", "5", "2"}},
+		{"SELECT id FROM users ORDER BY age DESC, id", []string{"5", "2", "9", "10"}},
+		{"SELECT id FROM users ORDER BY age NULLS LAST, id", []string{"5", "2", "9", "10"}},
+		{"SELECT id FROM users ORDER BY age DESC NULLS FIRST, id", []string{"2", "9", "10", "5"}},
+	}
+
+	for _, tt := range tests {
+		result, err := db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+
+		var got []string
+		for _, row := range result.rows {
+			got = append(got, row[0])
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, got)
+		}
+	}
+
+	if _, err := db.Execute("SELECT id FROM users ORDER BY 2"); err == nil {
+		t.Fatalf("Expected error for an ORDER BY position outside the select list")
+	}
+}
+
+func TestLimitOffset(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if _, err := db.Execute("CREATE TABLE users (id INTEGER)"); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if _, err := db.Execute(fmt.Sprintf("INSERT INTO users VALUES (%d)", i)); err != nil {
+			t.Fatalf("Failed to insert row: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"SELECT id FROM users ORDER BY id LIMIT 3", []string{"0", "1", "2"}},
+		{"SELECT id FROM users ORDER BY id DESC LIMIT 3 OFFSET 2", []string{"17", "16", "15"}},
+		{"SELECT id FROM users ORDER BY id LIMIT 5 OFFSET 18", []string{"18", "19"}},
+		{"SELECT id FROM users ORDER BY id LIMIT 5 OFFSET 30", nil},
+		{"SELECT id FROM users ORDER BY id LIMIT 0", nil},
+		{"SELECT id FROM users LIMIT 0", nil},
+		{"SELECT id FROM users LIMIT 0 OFFSET 5", nil},
+	}
+
+	for _, tt := range tests {
+		result, err := db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+
+		var got []string
+		for _, row := range result.rows {
+			got = append(got, row[0])
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, got)
+		}
+	}
+
+	result, err := db.Execute("SELECT id FROM users LIMIT 4")
+	if err != nil {
+		t.Fatalf("Failed to select: %v", err)
+	}
+
+	if len(result.rows) != 4 {
+		t.Fatalf("Expected 4 rows, got %d", len(result.rows))
+	}
+
+	if _, err := db.Execute("SELECT id FROM users LIMIT 'a'"); err == nil {
+		t.Fatalf("Expected error for a non-integer limit")
+	}
+}
+
+func TestAggregates(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE sales (region STRING, amount INTEGER, name STRING)",
+		"INSERT INTO sales VALUES ('north', 10, 'a')",
+		"INSERT INTO sales VALUES ('north', 20, 'b')",
+		"INSERT INTO sales VALUES ('south', 5, 'a')",
+		"INSERT INTO sales VALUES ('south', 7, 'c')",
+		"INSERT INTO sales VALUES ('west', 1, 'a')",
+		"CREATE TABLE empty (amount INTEGER)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT COUNT(*) FROM sales", [][]string{{"5"}}},
+		{"SELECT COUNT(*), SUM(amount), MIN(amount), MAX(amount), AVG(amount) FROM sales", [][]string{{"5", "43", "1", "20", "8"}}},
+		{"SELECT COUNT(DISTINCT name), COUNT(name) FROM sales", [][]string{{"3", "5"}}},
+		{"SELECT region, COUNT(*), SUM(amount) FROM sales GROUP BY region ORDER BY region", [][]string{
+			{"north", "2", "30"},
+			{"south", "2", "12"},
+			{"west", "1", "1"},
+		}},
+		{"SELECT region FROM sales GROUP BY region HAVING COUNT(*) = 2 ORDER BY SUM(amount) DESC", [][]string{{"north"}, {"south"}}},
+		{"SELECT region, MAX(name) FROM sales WHERE amount < 10 GROUP BY 1 ORDER BY 1 LIMIT 1", [][]string{{"south", "c"}}},
+		{"SELECT COUNT(*), SUM(amount), MIN(amount) FROM empty", [][]string{{"0", "", ""}}},
+		{"SELECT amount FROM empty GROUP BY amount", nil},
+	}
+
+	for _, tt := range tests {
+		result, err := db.Execute(tt.query)
+		if err != nil {
+			t.Fatalf("Failed to execute %q: %v", tt.query, err)
+		}
+
+		if !reflect.DeepEqual(result.rows, tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.query, tt.want, result.rows)
+		}
+	}
+
+	result, err := db.Execute("SELECT GROUP_CONCAT(name, '|') FROM sales WHERE region = 'north'")
+	if err != nil {
+		t.Fatalf("Failed to execute group_concat: %v", err)
+	}
+
+	parts := strings.Split(result.rows[0][0], "|")
+	sort.Strings(parts)
+	if !reflect.DeepEqual(parts, []string{"a", "b"}) {
+		t.Fatalf("Unexpected group_concat result: %v", result.rows[0][0])
+	}
+
+	for _, q := range []string{
+		"SELECT name FROM sales WHERE COUNT(*) = 1",
+		"SELECT SUM(COUNT(*)) FROM sales",
+		"SELECT SUM(*) FROM sales",
+		// the arguments are checked even when no row is aggregated
+		"SELECT SUM(amount, amount) FROM empty",
+		"SELECT COUNT() FROM empty",
+		"SELECT GROUP_CONCAT(amount, ',', ',') FROM empty",
+		"SELECT SUM(name) FROM sales",
+		"SELECT AVG(region) FROM sales GROUP BY region",
+	} {
+		if _, err := db.Execute(q); err == nil {
+			t.Errorf("Expected error for %q", q)
+		}
+	}
+}
+
+func TestDistinct(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE vals (v INTEGER, w STRING)",
+		"INSERT INTO vals VALUES (1, 'a')",
+		"INSERT INTO vals VALUES ('1', 'a')",
+		"INSERT INTO vals VALUES (1, 'b')",
+		"INSERT INTO vals VALUES (2, 'a')",
+		"INSERT INTO vals VALUES ('1', 'a')",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT DISTINCT v FROM vals ORDER BY v", [][]string{{"1"}, {"2"}, {"1"}}},
+		{"SELECT DISTINCT v, w FROM vals ORDER BY v, w", [][]string{{"1", "a"}, {"1", "b"}, {"2", "a"}, {"1", "a"}}},
+		{"SELECT DISTINCT w FROM vals ORDER BY w LIMIT 1", [][]string{{"a"}}},
+		{"SELECT COUNT(DISTINCT v), COUNT(DISTINCT w) FROM vals", [][]string{{"3", "2"}}},
+	}
+
+	run := func() {
+		for _, tt := range tests {
+			result, err := db.Execute(tt.query)
+			if err != nil {
+				t.Fatalf("Failed to execute %q: %v", tt.query, err)
+			}
+
+			if !reflect.DeepEqual(result.rows, tt.want) {
+				t.Errorf("%q: expected %v, got %v", tt.query, tt.want, result.rows)
+			}
+		}
+	}
+
+	run()
+
+	// with a tiny budget every key spills to a scratch space, which has to be
+	// cleaned up after the query.
+	db.SetMemoryBudget(1)
+	run()
+
+	iter := db.executor.storage.(*leveldbStorage).db.NewIterator(util.BytesPrefix([]byte(scratchPrefix)), nil)
+	defer iter.Release()
+	if iter.Next() {
+		t.Fatalf("Scratch keys were left behind: %q", iter.Key())
+	}
+}
+
+func TestJoins(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, q := range []string{
+		"CREATE TABLE users (id INTEGER, name STRING)",
+		"CREATE TABLE orders (id INTEGER, uid INTEGER, item STRING)",
+		"CREATE TABLE items (name STRING, price INTEGER)",
+		"INSERT INTO users VALUES (1, 'Alice')",
+		"INSERT INTO users VALUES (2, 'Bob')",
+		"INSERT INTO users VALUES (3, 'Carol')",
+		"INSERT INTO orders VALUES (1, 1, 'book')",
+		"INSERT INTO orders VALUES (2, 1, 'pen')",
+		"INSERT INTO orders VALUES (3, 2, 'book')",
+		"INSERT INTO orders VALUES (4, 9, 'lamp')",
+		"INSERT INTO items VALUES ('book', 10)",
+		"INSERT INTO items VALUES ('pen', 2)",
+	} {
+		if _, err := db.Execute(q); err != nil {
+			t.Fatalf("Failed to execute %q: %v", q, err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  [][]string
+	}{
+		{"SELECT u.name, o.item FROM users AS u JOIN orders o ON u.id = o.uid ORDER BY o.id", [][]string{
+			{"Alice", "book"}, {"Alice", "pen"}, {"Bob", "book"},
+		}},
+		{"SELECT name, item FROM users LEFT JOIN orders ON users.id = orders.uid ORDER BY name, item", [][]string{
+			{"Alice", "book"}, {"Alice", "pen"}, {"Bob", "book"}, {"Carol", ""},
+		}},
+		{"SELECT u.name, o.item FROM users u RIGHT JOIN orders o ON u.id = o.uid ORDER BY o.id", [][]string{
+			{"Alice", "book"}, {"Alice", "pen"}, {"Bob", "book"}, {"", "lamp"},
+		}},
+		{"SELECT u.name, o.item FROM users u FULL OUTER JOIN orders o ON u.id = o.uid ORDER BY u.name, o.item", [][]string{
+			{"", "lamp"}, {"Alice", "book"}, {"Alice", "pen"}, {"Bob", "book"}, {"Carol", ""},
+		}},
+		{"SELECT COUNT(*) FROM users CROSS JOIN orders", [][]string{{"12"}}},
+		{"SELECT u.name, SUM(i.price) FROM users u JOIN orders o ON u.id = o.uid JOIN items i ON o.item = i.name GROUP BY u.name ORDER BY u.name", [][]string{
+			{"Alice", "12"}, {"Bob", "10"},
+		}},
+		{"SELECT a.name, b.name FROM users a JOIN users b ON a.id < b.id WHERE a.id = 1 ORDER BY b.id", [][]string{
+			{"Alice", "Bob"}, {"Alice", "Carol"},
+		}},
+		{"SELECT o.item, u.name FROM orders o LEFT JOIN users u ON o.uid = u.id ORDER BY o.id", [][]string{
+			{"book", "Alice"}, {"pen", "Alice"}, {"book", "Bob"}, {"lamp", ""},
+		}},
+		{"SELECT COUNT(*) FROM orders a JOIN orders b ON a.uid = b.uid", [][]string{{"6"}}},
+		{"SELECT u.name, o.item FROM users u LEFT JOIN orders o ON u.id = o.uid AND o.item <> 'pen' ORDER BY u.name", [][]string{
+			{"Alice", "book"}, {"Bob", "book"}, {"Carol", ""},
+		}},
+	}
+
+	run := func() {
+		for _, tt := range tests {
+			result, err := db.Execute(tt.query)
+			if err != nil {
+				t.Fatalf("Failed to execute %q: %v", tt.query, err)
+			}
+
+			if !reflect.DeepEqual(result.rows, tt.want) {
+				t.Errorf("%q: expected %v, got %v", tt.query, tt.want, result.rows)
+			}
+		}
+	}
+	run()
+
+	// with a tiny budget the hash joins partition both inputs in a scratch space.
+	db.SetMemoryBudget(1)
+	run()
+
+	iter := db.executor.storage.(*leveldbStorage).db.NewIterator(util.BytesPrefix([]byte(scratchPrefix)), nil)
+	defer iter.Release()
+	if iter.Next() {
+		t.Fatalf("Scratch keys were left behind: %q", iter.Key())
+	}
+
+	if _, err := db.Execute("SELECT name FROM users JOIN missing ON users.id = missing.id"); err == nil {
+		t.Fatalf("Expected error when joining an unknown table")
+	}
+
+	// id is a column of both users and orders
+	for _, q := range []string{
+		"SELECT id FROM users JOIN orders ON users.id = orders.uid",
+		"SELECT u.name FROM users u JOIN orders o ON u.id = o.uid WHERE id = 1",
+		"SELECT a.name FROM users a JOIN users b ON a.id = b.id ORDER BY name",
+	} {
+		if _, err := db.Execute(q); err == nil || !strings.Contains(err.Error(), "ambiguous column") {
+			t.Errorf("Expected an ambiguous column error for %q, got %v", q, err)
+		}
+	}
+}
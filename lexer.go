@@ -8,13 +8,79 @@ import (
 const (
 	selectToken int = iota
 	createTableToken
+	createIndexToken
+	createUniqueIndexToken
+	dropIndexToken
 	insertToken
+	updateToken
+	setToken
+	deleteToken
+	dropTableToken
+	truncateTableToken
+	beginToken
+	commitToken
+	rollbackToken
+	ifExistsToken
+	alterTableToken
+	addColumnToken
+	dropColumnToken
+	renameColumnToken
+	renameToToken
+	toToken
+	defaultToken
+	primaryKeyToken
+	orderByToken
+	ascToken
+	descToken
+	nullsFirstToken
+	nullsLastToken
+	limitToken
+	offsetToken
+	groupByToken
+	havingToken
+	distinctToken
+	asToken
+	asOfSystemTimeToken
+	joinToken
+	leftJoinToken
+	rightJoinToken
+	fullJoinToken
+	crossJoinToken
+	onToken
+	dotToken
 	valuesToken
 	fromToken
 	whereToken
+	andToken
+	orToken
+	notToken
+	isToken
+	isNotToken
+	isDistinctFromToken
+	isNotDistinctFromToken
+	nullToken
+	inToken
+	betweenToken
+	likeToken
+	ilikeToken
+	globToken
+	escapeToken
+	caseToken
+	whenToken
+	thenToken
+	elseToken
+	endToken
 	plusToken
 	equalToken
+	neqToken
 	ltToken
+	leToken
+	gtToken
+	geToken
+	minusToken
+	starToken
+	slashToken
+	percentToken
 	concattoken
 	leftParenToken
 	rightParenToken
@@ -41,15 +107,87 @@ type builtin struct {
 
 var builtins = [...]builtin{
 	{name: "CREATE TABLE", tokType: createTableToken},
+	{name: "CREATE INDEX", tokType: createIndexToken},
+	{name: "CREATE UNIQUE INDEX", tokType: createUniqueIndexToken},
+	{name: "DROP INDEX", tokType: dropIndexToken},
 	{name: "INSERT INTO", tokType: insertToken},
+	{name: "UPDATE", tokType: updateToken},
+	{name: "SET", tokType: setToken},
+	{name: "DELETE FROM", tokType: deleteToken},
+	{name: "DROP TABLE", tokType: dropTableToken},
+	{name: "TRUNCATE TABLE", tokType: truncateTableToken},
+	{name: "BEGIN", tokType: beginToken},
+	{name: "COMMIT", tokType: commitToken},
+	{name: "ROLLBACK", tokType: rollbackToken},
+	{name: "IF EXISTS", tokType: ifExistsToken},
+	{name: "ALTER TABLE", tokType: alterTableToken},
+	{name: "ADD COLUMN", tokType: addColumnToken},
+	{name: "DROP COLUMN", tokType: dropColumnToken},
+	{name: "RENAME COLUMN", tokType: renameColumnToken},
+	{name: "RENAME TO", tokType: renameToToken},
+	{name: "TO", tokType: toToken},
+	{name: "DEFAULT", tokType: defaultToken},
+	{name: "PRIMARY KEY", tokType: primaryKeyToken},
+	{name: "ORDER BY", tokType: orderByToken},
+	{name: "ASC", tokType: ascToken},
+	{name: "DESC", tokType: descToken},
+	{name: "NULLS FIRST", tokType: nullsFirstToken},
+	{name: "NULLS LAST", tokType: nullsLastToken},
+	{name: "LIMIT", tokType: limitToken},
+	{name: "OFFSET", tokType: offsetToken},
+	{name: "GROUP BY", tokType: groupByToken},
+	{name: "HAVING", tokType: havingToken},
+	{name: "DISTINCT", tokType: distinctToken},
+	{name: "AS OF SYSTEM TIME", tokType: asOfSystemTimeToken},
+	{name: "AS", tokType: asToken},
+	{name: "JOIN", tokType: joinToken},
+	{name: "INNER JOIN", tokType: joinToken},
+	{name: "LEFT JOIN", tokType: leftJoinToken},
+	{name: "LEFT OUTER JOIN", tokType: leftJoinToken},
+	{name: "RIGHT JOIN", tokType: rightJoinToken},
+	{name: "RIGHT OUTER JOIN", tokType: rightJoinToken},
+	{name: "FULL JOIN", tokType: fullJoinToken},
+	{name: "FULL OUTER JOIN", tokType: fullJoinToken},
+	{name: "CROSS JOIN", tokType: crossJoinToken},
+	{name: "ON", tokType: onToken},
+	{name: ".", tokType: dotToken},
 	{name: "SELECT", tokType: selectToken},
 	{name: "VALUES", tokType: valuesToken},
 	{name: "WHERE", tokType: whereToken},
 	{name: "FROM", tokType: fromToken},
+	{name: "AND", tokType: andToken},
+	{name: "OR", tokType: orToken},
+	{name: "NOT", tokType: notToken},
+	{name: "IS NOT DISTINCT FROM", tokType: isNotDistinctFromToken},
+	{name: "IS DISTINCT FROM", tokType: isDistinctFromToken},
+	{name: "IS NOT", tokType: isNotToken},
+	{name: "IS", tokType: isToken},
+	{name: "NULL", tokType: nullToken},
+	{name: "IN", tokType: inToken},
+	{name: "BETWEEN", tokType: betweenToken},
+	{name: "LIKE", tokType: likeToken},
+	{name: "ILIKE", tokType: ilikeToken},
+	{name: "GLOB", tokType: globToken},
+	{name: "ESCAPE", tokType: escapeToken},
+	{name: "CASE", tokType: caseToken},
+	{name: "WHEN", tokType: whenToken},
+	{name: "THEN", tokType: thenToken},
+	{name: "ELSE", tokType: elseToken},
+	{name: "END", tokType: endToken},
 	{name: "||", tokType: concattoken},
+	{name: "==", tokType: equalToken},
 	{name: "=", tokType: equalToken},
-	{name: "+", tokType: plusToken},
+	{name: "<>", tokType: neqToken},
+	{name: "!=", tokType: neqToken},
+	{name: "<=", tokType: leToken},
 	{name: "<", tokType: ltToken},
+	{name: ">=", tokType: geToken},
+	{name: ">", tokType: gtToken},
+	{name: "+", tokType: plusToken},
+	{name: "-", tokType: minusToken},
+	{name: "*", tokType: starToken},
+	{name: "/", tokType: slashToken},
+	{name: "%", tokType: percentToken},
 	{name: "(", tokType: leftParenToken},
 	{name: ")", tokType: rightParenToken},
 	{name: ",", tokType: commaToken},
@@ -66,11 +204,44 @@ func (l *lexer) whitespace() {
 	}
 }
 
+func isWordChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
+}
+
+// matchKeyword returns the index right after the keyword if it starts at the
+// current position, otherwise -1. The spaces in multi-word keywords match any
+// amount of whitespace and word keywords need to end at a word boundary, so that
+// an identifier like "settings" is not lexed as SET followed by "tings".
+func (l *lexer) matchKeyword(name string) int {
+	i := l.index
+	for j := 0; j < len(name); j++ {
+		if name[j] == ' ' {
+			start := i
+			for i < len(l.content) && unicode.IsSpace(rune(l.content[i])) {
+				i++
+			}
+			if start == i {
+				return -1
+			}
+			continue
+		}
+
+		if i >= len(l.content) || !strings.EqualFold(l.content[i:i+1], name[j:j+1]) {
+			return -1
+		}
+		i++
+	}
+
+	if isWordChar(name[len(name)-1]) && i < len(l.content) && isWordChar(l.content[i]) {
+		return -1
+	}
+	return i
+}
+
 func (l *lexer) keyword() token {
 	for _, b := range builtins {
-		if l.index+len(b.name) <= len(l.content) &&
-			strings.EqualFold(l.content[l.index:l.index+len(b.name)], b.name) {
-			l.index += len(b.name)
+		if end := l.matchKeyword(b.name); end != -1 {
+			l.index = end
 			return token{tokType: b.tokType, content: b.name}
 		}
 	}
@@ -104,12 +275,14 @@ func (l *lexer) str() token {
 	return token{tokType: stringToken, content: l.content[start : l.index-1]}
 }
 
+// identifier lexes a name of letters, digits and underscores that doesn't start
+// with a digit, like c1.
 func (l *lexer) identifier() token {
 	start := l.index
-	for l.index < len(l.content) &&
-		((l.content[l.index] >= 'a' && l.content[l.index] <= 'z') ||
-			(l.content[l.index] >= 'A' && l.content[l.index] <= 'Z') ||
-			l.content[l.index] == '*') {
+	for l.index < len(l.content) && isWordChar(l.content[l.index]) {
+		if l.index == start && l.content[l.index] >= '0' && l.content[l.index] <= '9' {
+			break
+		}
 		l.index++
 	}
 	if start == l.index {
@@ -23,6 +23,13 @@ func TestLexer_Keyword(t *testing.T) {
 		{"SELECT keyword", "SELECT", token{tokType: selectToken}, 6},
 		{"CREATE TABLE keyword", "CREATE TABLE", token{tokType: createTableToken}, 12},
 		{"Invalid keyword", "INVALID", token{tokType: invalidToken}, 0},
+		{"Multi-word keyword with extra whitespace", "INSERT   INTO", token{tokType: insertToken}, 13},
+		{"Keyword prefix of identifier", "settings", token{tokType: invalidToken}, 0},
+		{"Longest keyword wins", "is not distinct from", token{tokType: isNotDistinctFromToken}, 20},
+		{"NULL is not NULLS", "NULLS FIRST", token{tokType: nullsFirstToken}, 11},
+		{"PRIMARY KEY keyword", "primary  key", token{tokType: primaryKeyToken}, 12},
+		{"UNIQUE INDEX is not INDEX", "CREATE UNIQUE INDEX", token{tokType: createUniqueIndexToken}, 19},
+		{"AS OF SYSTEM TIME before AS", "as of system time", token{tokType: asOfSystemTimeToken}, 17},
 	}
 
 	for _, tt := range tests {
@@ -96,7 +103,8 @@ func TestLexer_Identifier(t *testing.T) {
 		expected token
 		newIndex int
 	}{
-		{"Valid identifier", "abc123", token{tokType: identifierToken, content: "abc"}, 3},
+		{"Valid identifier", "abc123", token{tokType: identifierToken, content: "abc123"}, 6},
+		{"Identifier with underscore", "_c1 x", token{tokType: identifierToken, content: "_c1"}, 3},
 		{"Invalid identifier", "123abc", token{tokType: invalidToken}, 0},
 	}
 
@@ -124,28 +132,44 @@ func TestLexer_Lex(t *testing.T) {
 			name:  "Simple SELECT query",
 			input: "SELECT * FROM users WHERE id = 1",
 			expected: []token{
-				{tokType: selectToken},
-				{tokType: identifierToken, content: "*"},
-				{tokType: fromToken},
+				{tokType: selectToken, content: "SELECT"},
+				{tokType: starToken, content: "*"},
+				{tokType: fromToken, content: "FROM"},
 				{tokType: identifierToken, content: "users"},
-				{tokType: whereToken},
+				{tokType: whereToken, content: "WHERE"},
 				{tokType: identifierToken, content: "id"},
-				{tokType: equalToken},
+				{tokType: equalToken, content: "="},
 				{tokType: integerToken, content: "1"},
 			},
 		},
+		{
+			name:  "Operators",
+			input: "a<=b*-2 <> c>d",
+			expected: []token{
+				{tokType: identifierToken, content: "a"},
+				{tokType: leToken, content: "<="},
+				{tokType: identifierToken, content: "b"},
+				{tokType: starToken, content: "*"},
+				{tokType: minusToken, content: "-"},
+				{tokType: integerToken, content: "2"},
+				{tokType: neqToken, content: "<>"},
+				{tokType: identifierToken, content: "c"},
+				{tokType: gtToken, content: ">"},
+				{tokType: identifierToken, content: "d"},
+			},
+		},
 		{
 			name:  "INSERT query",
 			input: "INSERT INTO users VALUES ('John', 30)",
 			expected: []token{
-				{tokType: insertToken},
+				{tokType: insertToken, content: "INSERT INTO"},
 				{tokType: identifierToken, content: "users"},
-				{tokType: valuesToken},
-				{tokType: leftParenToken},
+				{tokType: valuesToken, content: "VALUES"},
+				{tokType: leftParenToken, content: "("},
 				{tokType: stringToken, content: "John"},
-				{tokType: commaToken},
+				{tokType: commaToken, content: ","},
 				{tokType: integerToken, content: "30"},
-				{tokType: rightParenToken},
+				{tokType: rightParenToken, content: ")"},
 			},
 		},
 	}
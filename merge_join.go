@@ -0,0 +1,220 @@
+package levelsql
+
+// mergeJoin joins two inputs that are both sorted on their join key, like scans
+// in primary key order. Both inputs are read once side by side, only the right
+// rows of the current key are kept in memory.
+type mergeJoin struct {
+	e                 *exec
+	kind              joinKind
+	left, right       rowSource
+	leftKey, rightKey node
+	residual          []node
+	joined            *table
+}
+
+func (mj *mergeJoin) schema() *table {
+	return mj.joined
+}
+
+func (mj *mergeJoin) size() int64 {
+	return mj.left.size() + mj.right.size()
+}
+
+// the output is sorted like the left input, unless unmatched right rows with
+// NULLs on the left side are mixed in.
+func (mj *mergeJoin) orderedBy() int {
+	if mj.kind.keepsRight() {
+		return -1
+	}
+	return mj.left.(orderedSource).orderedBy()
+}
+
+func (mj *mergeJoin) open() (storageIterator, error) {
+	leftIter, err := mj.left.open()
+	if err != nil {
+		return nil, err
+	}
+
+	rightIter, err := mj.right.open()
+	if err != nil {
+		leftIter.Close()
+		return nil, err
+	}
+
+	it := &mergeJoinIterator{
+		join:        mj,
+		leftIter:    leftIter,
+		rightIter:   rightIter,
+		leftColumns: len(mj.left.schema().Columns),
+	}
+	it.keepLeft, it.keepRight = mj.kind.keepsLeft(), mj.kind.keepsRight()
+
+	it.advanceLeft()
+	it.advanceRight()
+	return it, nil
+}
+
+type mergeJoinIterator struct {
+	join                *mergeJoin
+	leftIter, rightIter storageIterator
+	leftColumns         int
+	keepLeft, keepRight bool
+
+	// the current row of each side and the value of its key, a nil row means
+	// that the side has run out.
+	left, right       *row
+	leftKey, rightKey value
+
+	pending []*row
+	err     error
+}
+
+func (it *mergeJoinIterator) advanceLeft() {
+	if it.left != nil {
+		it.left.Release()
+	}
+
+	it.left = nil
+	if it.err != nil {
+		return
+	}
+
+	if r, ok := it.leftIter.Next(); ok {
+		it.left = r
+		it.leftKey, it.err = it.join.e.executeExpression(it.join.leftKey, r)
+	}
+}
+
+func (it *mergeJoinIterator) advanceRight() {
+	if it.right != nil {
+		it.right.Release()
+	}
+
+	it.right = nil
+	if it.err != nil {
+		return
+	}
+
+	if r, ok := it.rightIter.Next(); ok {
+		it.right = r
+		it.rightKey, it.err = it.join.e.executeExpression(it.join.rightKey, r)
+	}
+}
+
+func (it *mergeJoinIterator) emitLeft() {
+	if it.keepLeft {
+		it.pending = append(it.pending, combineRows(it.join.joined, it.leftColumns, it.left, nil))
+	}
+	it.advanceLeft()
+}
+
+func (it *mergeJoinIterator) emitRight() {
+	if it.keepRight {
+		it.pending = append(it.pending, combineRows(it.join.joined, it.leftColumns, nil, it.right))
+	}
+	it.advanceRight()
+}
+
+// mergeGroup joins every left row with the current key against every right row
+// with the same key.
+func (it *mergeJoinIterator) mergeGroup() error {
+	key := it.rightKey
+
+	var group []*hashEntry
+	for it.right != nil && it.err == nil && compareValues(it.rightKey, key) == 0 {
+		group = append(group, &hashEntry{cells: append([]value(nil), it.right.Cells...)})
+		it.advanceRight()
+	}
+
+	for it.left != nil && it.err == nil && compareValues(it.leftKey, key) == 0 {
+		matched := false
+		for _, entry := range group {
+			combined := combineRows(it.join.joined, it.leftColumns, it.left, &row{Cells: entry.cells})
+			ok, err := it.join.e.matchesAll(it.join.residual, combined)
+			if err != nil {
+				combined.Release()
+				return err
+			}
+			if !ok {
+				combined.Release()
+				continue
+			}
+
+			entry.matched = true
+			matched = true
+			it.pending = append(it.pending, combined)
+		}
+
+		if !matched && it.keepLeft {
+			it.pending = append(it.pending, combineRows(it.join.joined, it.leftColumns, it.left, nil))
+		}
+		it.advanceLeft()
+	}
+
+	if it.keepRight {
+		for _, entry := range group {
+			if !entry.matched {
+				it.pending = append(it.pending, combineRows(it.join.joined, it.leftColumns, nil, &row{Cells: entry.cells}))
+			}
+		}
+	}
+	return nil
+}
+
+func (it *mergeJoinIterator) Next() (*row, bool) {
+	for {
+		if len(it.pending) > 0 {
+			r := it.pending[0]
+			it.pending[0] = nil
+			it.pending = it.pending[1:]
+			return r, true
+		}
+
+		if it.err != nil {
+			return nil, false
+		}
+
+		switch {
+		case it.left == nil && it.right == nil:
+			return nil, false
+		case it.right == nil, it.left != nil && it.leftKey.ty == nullVal:
+			it.emitLeft()
+		case it.left == nil, it.rightKey.ty == nullVal:
+			it.emitRight()
+		default:
+			c := compareValues(it.leftKey, it.rightKey)
+			if c < 0 {
+				it.emitLeft()
+			} else if c > 0 {
+				it.emitRight()
+			} else if err := it.mergeGroup(); err != nil {
+				it.err = err
+			}
+		}
+	}
+}
+
+func (it *mergeJoinIterator) Close() error {
+	for _, r := range it.pending {
+		r.Release()
+	}
+	it.pending = nil
+
+	if it.left != nil {
+		it.left.Release()
+		it.left = nil
+	}
+	if it.right != nil {
+		it.right.Release()
+		it.right = nil
+	}
+
+	err := it.err
+	if closeErr := it.rightIter.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := it.leftIter.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,371 @@
+package levelsql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Rows are versioned by the commit timestamp of the write that stored them. A
+// version lives under the row key followed by the inverted timestamp, so the
+// versions of a row are next to each other and the newest one comes first. Its
+// value starts with a marker that tells if the row was written or deleted.
+//
+// The catalog and the index entries aren't versioned, a query in the past reads
+// the old rows with the current schema of the table.
+const versionedPrefix = "row_"
+
+// latestTimestamp reads the newest version of every row.
+const latestTimestamp uint64 = math.MaxUint64
+
+// clockKey holds the last commit timestamp, which keeps the timestamps growing
+// when the wall clock goes back between runs. A database without it was written
+// before rows had versions.
+var clockKey = []byte("mvcc_clock")
+
+// migrateKey holds the last row key that the migration to versions has moved,
+// so a migration that was cut short goes on after it instead of adding another
+// version to the rows it has already moved.
+var migrateKey = []byte("mvcc_migrate")
+
+const (
+	markDeleted byte = iota
+	markWritten
+)
+
+func isVersioned(key []byte) bool {
+	return bytes.HasPrefix(key, []byte(versionedPrefix))
+}
+
+func versionKey(key []byte, ts uint64) []byte {
+	return binary.BigEndian.AppendUint64(key[:len(key):len(key)], ^ts)
+}
+
+// splitVersion returns the row key and the timestamp of a version, ok is false
+// for keys that aren't versions.
+func splitVersion(key []byte) (rowKey []byte, ts uint64, ok bool) {
+	if !isVersioned(key) || len(key) < len(versionedPrefix)+8 {
+		return nil, 0, false
+	}
+	return key[:len(key)-8], ^binary.BigEndian.Uint64(key[len(key)-8:]), true
+}
+
+// versionClock hands out the commit timestamps and writes the batches that use
+// them one at a time, so a snapshot taken between two writes has every version
+// up to the last timestamp and none after it.
+type versionClock struct {
+	mu   sync.Mutex
+	db   *leveldb.DB
+	last uint64
+}
+
+// openClock reads the last commit timestamp. Rows of a database written before
+// they had versions are given the first timestamp.
+func openClock(db *leveldb.DB) (*versionClock, error) {
+	c := &versionClock{db: db}
+	value, err := db.Get(clockKey, nil)
+	if err == nil && len(value) == 8 {
+		c.last = binary.BigEndian.Uint64(value)
+		return c, nil
+	} else if err != nil && err != leveldb.ErrNotFound {
+		return nil, err
+	}
+
+	rows := util.BytesPrefix([]byte(versionedPrefix))
+	if moved, err := db.Get(migrateKey, nil); err == nil {
+		// no other row key starts with the moved one, so the keys up to its
+		// version are all versions already
+		rows.Start = append(versionKey(moved, 1), 0)
+	} else if err != leveldb.ErrNotFound {
+		return nil, err
+	}
+
+	iter := db.NewIterator(rows, nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+		batch.Put(versionKey(iter.Key(), 1), append([]byte{markWritten}, iter.Value()...))
+		if batch.Len() >= deleteBatchSize {
+			batch.Put(migrateKey, iter.Key())
+			if err := db.Write(batch, nil); err != nil {
+				return nil, err
+			}
+			batch.Reset()
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	c.last = 1
+	batch.Delete(migrateKey)
+	batch.Put(clockKey, binary.BigEndian.AppendUint64(nil, c.last))
+	return c, db.Write(batch, nil)
+}
+
+// write stores the batch with the versions of its rows at ts, or at the next
+// timestamp if ts is 0.
+func (c *versionClock) write(batch *leveldb.Batch, ts uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ts == 0 {
+		ts = uint64(time.Now().UnixNano())
+		if ts <= c.last {
+			ts = c.last + 1
+		}
+	}
+
+	stamped := &stamper{batch: new(leveldb.Batch), ts: ts}
+	if err := batch.Replay(stamped); err != nil {
+		return err
+	}
+
+	last := c.last
+	if ts > last {
+		last = ts
+	}
+	stamped.batch.Put(clockKey, binary.BigEndian.AppendUint64(nil, last))
+
+	if err := c.db.Write(stamped.batch, nil); err != nil {
+		return err
+	}
+	c.last = last
+	return nil
+}
+
+// snapshot takes a snapshot that no write is halfway into.
+func (c *versionClock) snapshot() (*leveldb.Snapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.db.GetSnapshot()
+}
+
+// stamper turns the writes of a batch into versions at ts, a delete of a row is
+// a version that marks the row as deleted.
+type stamper struct {
+	batch *leveldb.Batch
+	ts    uint64
+}
+
+func (s *stamper) Put(key, value []byte) {
+	if isVersioned(key) {
+		s.batch.Put(versionKey(key, s.ts), append([]byte{markWritten}, value...))
+		return
+	}
+	s.batch.Put(key, value)
+}
+
+func (s *stamper) Delete(key []byte) {
+	if isVersioned(key) {
+		s.batch.Put(versionKey(key, s.ts), []byte{markDeleted})
+		return
+	}
+	s.batch.Delete(key)
+}
+
+// versionedReader reads the rows as they were at ts from the versions that r
+// has. The rest of the keys are read as they are.
+type versionedReader struct {
+	r  kvReader
+	ts uint64
+}
+
+func (v *versionedReader) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	if !isVersioned(key) {
+		return v.r.Get(key, ro)
+	}
+
+	// the versions of the key that are at most ts, newest first
+	limit := append(versionKey(key, 0), 0)
+	iter := v.r.NewIterator(&util.Range{Start: versionKey(key, v.ts), Limit: limit}, ro)
+	defer iter.Release()
+
+	if !iter.Next() {
+		if err := iter.Error(); err != nil {
+			return nil, err
+		}
+		return nil, leveldb.ErrNotFound
+	}
+
+	value := iter.Value()
+	if value[0] == markDeleted {
+		return nil, leveldb.ErrNotFound
+	}
+	return append([]byte(nil), value[1:]...), nil
+}
+
+func (v *versionedReader) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
+	_, err := v.Get(key, ro)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// NewIterator walks the keys in the range with the version of every row that
+// was current at ts. The versions of a row key are all within a range of row
+// keys, as the key of a row never starts with the key of another one.
+func (v *versionedReader) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	return &versionIterator{iter: v.r.NewIterator(slice, ro), ts: v.ts}
+}
+
+type versionIterator struct {
+	forwardIterator
+	iter iterator.Iterator
+	ts   uint64
+
+	// current is the row key whose version at ts has been seen, its older
+	// versions are skipped
+	current []byte
+}
+
+func (vi *versionIterator) Next() bool {
+	for vi.err == nil && vi.iter.Next() {
+		rowKey, ts, ok := splitVersion(vi.iter.Key())
+		if !ok {
+			vi.key, vi.value = vi.iter.Key(), vi.iter.Value()
+			return true
+		}
+
+		if ts > vi.ts || (vi.current != nil && bytes.Equal(rowKey, vi.current)) {
+			continue
+		}
+
+		vi.current = append(vi.current[:0], rowKey...)
+		if value := vi.iter.Value(); value[0] == markWritten {
+			vi.key, vi.value = vi.current, value[1:]
+			return true
+		}
+	}
+
+	vi.key, vi.value = nil, nil
+	return false
+}
+
+func (vi *versionIterator) Error() error {
+	if vi.err != nil {
+		return vi.err
+	}
+	return vi.iter.Error()
+}
+
+func (vi *versionIterator) Release() {
+	vi.iter.Release()
+	vi.forwardIterator.Release()
+}
+
+// mvccStore reads the newest versions from the database and writes new ones.
+type mvccStore struct {
+	versionedReader
+	clock *versionClock
+}
+
+func newMVCCStore(clock *versionClock) *mvccStore {
+	return &mvccStore{
+		versionedReader: versionedReader{r: clock.db, ts: latestTimestamp},
+		clock:           clock,
+	}
+}
+
+func (m *mvccStore) Put(key, value []byte, wo *opt.WriteOptions) error {
+	batch := new(leveldb.Batch)
+	batch.Put(key, value)
+	return m.clock.write(batch, 0)
+}
+
+func (m *mvccStore) Delete(key []byte, wo *opt.WriteOptions) error {
+	batch := new(leveldb.Batch)
+	batch.Delete(key)
+	return m.clock.write(batch, 0)
+}
+
+func (m *mvccStore) Write(batch *leveldb.Batch, wo *opt.WriteOptions) error {
+	return m.clock.write(batch, 0)
+}
+
+// collectGarbage removes the versions that no read at or after before needs:
+// every version older than the one that was current at before, and that one
+// too if it deleted the row. It returns the number of versions removed.
+func collectGarbage(db *leveldb.DB, before uint64) (int, error) {
+	iter := db.NewIterator(util.BytesPrefix([]byte(versionedPrefix)), nil)
+	defer iter.Release()
+
+	removed := 0
+	batch := new(leveldb.Batch)
+	var current []byte
+	for iter.Next() {
+		rowKey, ts, ok := splitVersion(iter.Key())
+		if !ok || ts > before {
+			continue
+		}
+
+		// the first version at or before the cutoff is kept if the row
+		// still existed then
+		first := current == nil || !bytes.Equal(rowKey, current)
+		if first {
+			current = append(current[:0], rowKey...)
+		}
+		if first && iter.Value()[0] == markWritten {
+			continue
+		}
+
+		batch.Delete(iter.Key())
+		removed++
+		if batch.Len() >= deleteBatchSize {
+			if err := db.Write(batch, nil); err != nil {
+				return removed, err
+			}
+			batch.Reset()
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return removed, err
+	}
+	return removed, db.Write(batch, nil)
+}
+
+var errBackwardIteration = errors.New("iterators of the storage only move forward")
+
+// forwardIterator has the parts of iterator.Iterator that the iterators over
+// versions and buffered writes share. The storage only walks ranges from their
+// start, so they only support Next.
+type forwardIterator struct {
+	key, value []byte
+	err        error
+	releaser   util.Releaser
+}
+
+func (fi *forwardIterator) backward() bool {
+	fi.err = errBackwardIteration
+	fi.key, fi.value = nil, nil
+	return false
+}
+
+func (fi *forwardIterator) First() bool                 { return fi.backward() }
+func (fi *forwardIterator) Last() bool                  { return fi.backward() }
+func (fi *forwardIterator) Seek(key []byte) bool        { return fi.backward() }
+func (fi *forwardIterator) Prev() bool                  { return fi.backward() }
+func (fi *forwardIterator) Valid() bool                 { return fi.key != nil }
+func (fi *forwardIterator) Key() []byte                 { return fi.key }
+func (fi *forwardIterator) Value() []byte               { return fi.value }
+func (fi *forwardIterator) SetReleaser(r util.Releaser) { fi.releaser = r }
+
+func (fi *forwardIterator) Release() {
+	if fi.releaser != nil {
+		fi.releaser.Release()
+		fi.releaser = nil
+	}
+}
@@ -0,0 +1,206 @@
+package levelsql
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	nullsDefault int = iota
+	nullsFirst
+	nullsLast
+)
+
+type orderByTerm struct {
+	expr  node
+	desc  bool
+	nulls int
+}
+
+func (o orderByTerm) String() string {
+	var b strings.Builder
+	b.WriteString(o.expr.String())
+	if o.desc {
+		b.WriteString(" DESC")
+	}
+
+	switch o.nulls {
+	case nullsFirst:
+		b.WriteString(" NULLS FIRST")
+	case nullsLast:
+		b.WriteString(" NULLS LAST")
+	}
+
+	return b.String()
+}
+
+// columnOrdinal returns the zero based index of the result column that n refers
+// to, either by position like the 2 in ORDER BY 2 or by the alias of the column.
+// Aliases take precedence over the columns of the tables. Otherwise it returns -1.
+func columnOrdinal(n node, columns []node) (int, error) {
+	lit, ok := n.(*literalNode)
+	if ok && lit.lit.tokType == identifierToken {
+		for i, col := range columns {
+			if an, ok := col.(*aliasNode); ok && an.alias.content == lit.lit.content {
+				return i, nil
+			}
+		}
+	}
+
+	if !ok || lit.lit.tokType != integerToken {
+		return -1, nil
+	}
+
+	pos, err := strconv.Atoi(lit.lit.content)
+	if err != nil || pos < 1 || pos > len(columns) {
+		return -1, fmt.Errorf("position %s is not in select list", lit.lit.content)
+	}
+
+	return pos - 1, nil
+}
+
+// typeRank orders values of different types: NULL sorts before booleans, which
+// sort before integers, which sort before strings.
+func typeRank(v value) int {
+	switch v.ty {
+	case boolVal:
+		return 1
+	case integerVal:
+		return 2
+	case stringVal:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// compareValues returns -1, 0 or 1 depending on whether a sorts before, equal to
+// or after b. The comparison is typed, so the integer 10 sorts after the
+// integer 9 even though "10" < "9" as strings.
+func compareValues(a, b value) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	switch a.ty {
+	case boolVal:
+		if a.boolVal == b.boolVal {
+			return 0
+		} else if !a.boolVal {
+			return -1
+		}
+		return 1
+	case integerVal:
+		if a.integerVal < b.integerVal {
+			return -1
+		} else if a.integerVal > b.integerVal {
+			return 1
+		}
+		return 0
+	case stringVal:
+		return strings.Compare(a.stringVal, b.stringVal)
+	default:
+		return 0
+	}
+}
+
+// compareSortKeys compares two rows by their evaluated ORDER BY keys. By default
+// NULL is the smallest value, so it comes first in ascending and last in
+// descending order.
+func compareSortKeys(terms []orderByTerm, a, b []value) int {
+	for i, term := range terms {
+		aNull, bNull := a[i].ty == nullVal, b[i].ty == nullVal
+		if aNull || bNull {
+			if aNull && bNull {
+				continue
+			}
+
+			first := term.nulls == nullsFirst || (term.nulls == nullsDefault && !term.desc)
+			if aNull == first {
+				return -1
+			}
+			return 1
+		}
+
+		c := compareValues(a[i], b[i])
+		if term.desc {
+			c = -c
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// compareResults orders select results by their sort keys. Ties are broken by
+// the order in which the rows were produced, which keeps the sort stable.
+func compareResults(terms []orderByTerm, a, b *selectResult) int {
+	if c := compareSortKeys(terms, a.sortKeys, b.sortKeys); c != 0 {
+		return c
+	}
+
+	if a.seq < b.seq {
+		return -1
+	} else if a.seq > b.seq {
+		return 1
+	}
+	return 0
+}
+
+func sortResults(terms []orderByTerm, results []selectResult) {
+	sort.Slice(results, func(i, j int) bool {
+		return compareResults(terms, &results[i], &results[j]) < 0
+	})
+}
+
+// topN keeps the n first results in ORDER BY order without holding every row in
+// memory. It is a max-heap, so the root is the result that is dropped first.
+type topN struct {
+	terms   []orderByTerm
+	n       int
+	results []selectResult
+}
+
+func (t *topN) Len() int { return len(t.results) }
+func (t *topN) Less(i, j int) bool {
+	return compareResults(t.terms, &t.results[i], &t.results[j]) > 0
+}
+func (t *topN) Swap(i, j int)      { t.results[i], t.results[j] = t.results[j], t.results[i] }
+func (t *topN) Push(x interface{}) { t.results = append(t.results, x.(selectResult)) }
+func (t *topN) Pop() interface{} {
+	last := t.results[len(t.results)-1]
+	t.results = t.results[:len(t.results)-1]
+	return last
+}
+
+func (t *topN) add(res selectResult) {
+	if t.n <= 0 {
+		return
+	}
+
+	if len(t.results) < t.n {
+		heap.Push(t, res)
+		return
+	}
+
+	if compareResults(t.terms, &res, &t.results[0]) < 0 {
+		t.results[0] = res
+		heap.Fix(t, 0)
+	}
+}
+
+// sorted returns the kept results in ORDER BY order.
+func (t *topN) sorted() []selectResult {
+	sortResults(t.terms, t.results)
+	return t.results
+}
@@ -1,6 +1,9 @@
 package levelsql
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 type parser struct {
 	tokens []token
@@ -29,10 +32,235 @@ func (p *parser) consume(ty int) bool {
 	return false
 }
 
+// binaryPrecedence is the binding strength of every binary operator, operators
+// with a higher value bind tighter. All of them are left-associative.
+var binaryPrecedence = map[int]int{
+	orToken:                1,
+	andToken:               2,
+	equalToken:             4,
+	neqToken:               4,
+	ltToken:                4,
+	leToken:                4,
+	gtToken:                4,
+	geToken:                4,
+	isToken:                4,
+	isNotToken:             4,
+	isDistinctFromToken:    4,
+	isNotDistinctFromToken: 4,
+	plusToken:              5,
+	minusToken:             5,
+	starToken:              6,
+	slashToken:             6,
+	percentToken:           6,
+	concattoken:            7,
+}
+
+// notPrecedence is the binding strength of the prefix NOT. It binds looser than
+// the comparisons, so NOT a = b is NOT (a = b), but tighter than AND.
+const notPrecedence = 3
+
+// predicatePrecedence is the binding strength of [NOT] IN, BETWEEN, LIKE, ILIKE
+// and GLOB, the same as the comparisons.
+const predicatePrecedence = 4
+
+// unaryPrecedence is the binding strength of the unary minus, which binds
+// tighter than every binary operator.
+const unaryPrecedence = 8
+
 func (p *parser) expr() (node, error) {
+	return p.binary(0)
+}
+
+// binary parses operators that bind at least as tight as minPrec by precedence
+// climbing. The right operand only takes operators that bind tighter than the
+// current one, which makes 1 - 2 - 3 parse as (1 - 2) - 3.
+func (p *parser) binary(minPrec int) (node, error) {
+	var exp node
+	var err error
+	if p.expect(notToken) && minPrec <= notPrecedence {
+		op := p.tokens[p.index]
+		p.index++
+
+		operand, err := p.binary(notPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		exp = &unaryNode{op: op, operand: operand}
+	} else if exp, err = p.unary(); err != nil {
+		return nil, err
+	}
+
+	for p.index < len(p.tokens) {
+		if p.isPredicate() {
+			if predicatePrecedence < minPrec {
+				break
+			}
+
+			if exp, err = p.predicate(exp); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		prec, ok := binaryPrecedence[p.tokens[p.index].tokType]
+		if !ok || prec < minPrec {
+			break
+		}
+
+		binExp := &binopNode{
+			left: exp,
+			op:   p.tokens[p.index],
+		}
+
+		p.index++
+		rhs, err := p.binary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		binExp.right = rhs
+		exp = binExp
+	}
+
+	return exp, nil
+}
+
+// isPredicate reports whether the next tokens start a predicate like IN or
+// NOT LIKE.
+func (p *parser) isPredicate() bool {
+	i := p.index
+	if p.expect(notToken) {
+		i++
+	}
+
+	if i >= len(p.tokens) {
+		return false
+	}
+
+	switch p.tokens[i].tokType {
+	case inToken, betweenToken, likeToken, ilikeToken, globToken:
+		return true
+	}
+	return false
+}
+
+// predicate parses the predicate that tests exp. Its operands take only
+// operators that bind tighter, so the AND of a BETWEEN isn't taken as a logical
+// AND.
+func (p *parser) predicate(exp node) (node, error) {
+	not := p.consume(notToken)
+	op := p.tokens[p.index]
+	p.index++
+
+	operand := func() (node, error) {
+		return p.binary(predicatePrecedence + 1)
+	}
+
+	switch op.tokType {
+	case inToken:
+		if !p.consume(leftParenToken) {
+			return nil, errors.New("expected '(' after IN")
+		}
+
+		in := &inNode{expr: exp, not: not}
+		for !p.consume(rightParenToken) {
+			if len(in.list) > 0 && !p.consume(commaToken) {
+				return nil, errors.New("expected comma in IN list")
+			}
+
+			item, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			in.list = append(in.list, item)
+		}
+
+		if len(in.list) == 0 {
+			return nil, errors.New("IN list cannot be empty")
+		}
+		return in, nil
+	case betweenToken:
+		low, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.consume(andToken) {
+			return nil, errors.New("expected AND in BETWEEN")
+		}
+
+		high, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		return &betweenNode{expr: exp, low: low, high: high, not: not}, nil
+	default:
+		pattern, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		like := &likeNode{op: op, expr: exp, pattern: pattern, not: not}
+		if p.consume(escapeToken) {
+			if op.tokType == globToken {
+				return nil, errors.New("GLOB does not take an ESCAPE clause")
+			}
+
+			if like.escape, err = operand(); err != nil {
+				return nil, err
+			}
+		}
+		return like, nil
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if p.expect(minusToken) {
+		op := p.tokens[p.index]
+		p.index++
+
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	if p.consume(leftParenToken) {
+		exp, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.consume(rightParenToken) {
+			return nil, errors.New("expected closing parenthesis")
+		}
+		return exp, nil
+	}
+
+	if p.expect(starToken) {
+		exp := &literalNode{lit: p.tokens[p.index]}
+		p.index++
+		return exp, nil
+	}
+
 	var exp node
 	canBeFunction := false
 	callerToken := token{}
+	if p.consume(caseToken) {
+		return p.caseExpr()
+	}
+
+	if p.expect(nullToken) {
+		exp := &literalNode{lit: p.tokens[p.index]}
+		p.index++
+		return exp, nil
+	}
+
 	if p.expect(integerToken) || p.expect(identifierToken) || p.expect(stringToken) {
 		if p.tokens[p.index].tokType == identifierToken {
 			canBeFunction = true
@@ -41,29 +269,78 @@ func (p *parser) expr() (node, error) {
 
 		exp = &literalNode{lit: p.tokens[p.index]}
 		p.index++
+
+		// qualified column references like u.name are kept as a single identifier
+		if canBeFunction && p.expect(dotToken) {
+			if p.index+1 >= len(p.tokens) || p.tokens[p.index+1].tokType != identifierToken {
+				return nil, errors.New("expected column name after '.'")
+			}
+
+			qualified := callerToken
+			qualified.content += "." + p.tokens[p.index+1].content
+			exp = &literalNode{lit: qualified}
+			canBeFunction = false
+			p.index += 2
+		}
 	} else {
 		return nil, errors.New("no expression")
 	}
 
-	if p.expect(ltToken) || p.expect(equalToken) || p.expect(plusToken) || p.expect(concattoken) {
-		binExp := &binopNode{
-			left: exp,
-			op:   p.tokens[p.index],
+	if p.expect(leftParenToken) && canBeFunction {
+		call, err := p.parseFuncCall(callerToken)
+		if err != nil {
+			return nil, err
+		}
+		exp = call
+	}
+
+	return exp, nil
+}
+
+// caseExpr parses the rest of a CASE expression after the CASE keyword.
+func (p *parser) caseExpr() (node, error) {
+	cn := &caseNode{}
+	if !p.expect(whenToken) {
+		operand, err := p.expr()
+		if err != nil {
+			return nil, err
 		}
+		cn.operand = operand
+	}
 
-		p.index++
-		rhs, err := p.expr()
+	for p.consume(whenToken) {
+		cond, err := p.expr()
 		if err != nil {
 			return nil, err
 		}
 
-		binExp.right = rhs
-		exp = binExp
-	} else if p.expect(leftParenToken) && canBeFunction {
-		return p.parseFuncCall(callerToken)
+		if !p.consume(thenToken) {
+			return nil, errors.New("expected THEN after WHEN condition")
+		}
+
+		result, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		cn.whens = append(cn.whens, caseWhen{cond: cond, result: result})
 	}
 
-	return exp, nil
+	if len(cn.whens) == 0 {
+		return nil, errors.New("CASE needs at least one WHEN")
+	}
+
+	if p.consume(elseToken) {
+		elseExpr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		cn.elseExpr = elseExpr
+	}
+
+	if !p.consume(endToken) {
+		return nil, errors.New("expected END after CASE")
+	}
+	return cn, nil
 }
 
 func (p *parser) parseFuncCall(callerToken token) (node, error) {
@@ -75,6 +352,8 @@ func (p *parser) parseFuncCall(callerToken token) (node, error) {
 		return nil, errors.New("need parenthesis before call arguments")
 	}
 
+	callNode.distinct = p.consume(distinctToken)
+
 	for !p.expect(rightParenToken) {
 		if len(callNode.args) > 0 {
 			if !p.consume(commaToken) {
@@ -94,56 +373,411 @@ func (p *parser) parseFuncCall(callerToken token) (node, error) {
 		return nil, errors.New("expected closing call")
 	}
 
-	return callNode, nil
+	return callNode, nil
+}
+
+// consumeWord consumes an identifier that is used as a keyword only in one place,
+// like EXCLUDE after a *, so that it stays usable as a name everywhere else.
+func (p *parser) consumeWord(word string) bool {
+	if p.expect(identifierToken) && strings.EqualFold(p.tokens[p.index].content, word) {
+		p.index++
+		return true
+	}
+	return false
+}
+
+// parenthesized parses a list of items that is either in parentheses or a
+// single item without them.
+func (p *parser) parenthesized(item func() error) error {
+	if !p.consume(leftParenToken) {
+		return item()
+	}
+
+	for first := true; !p.consume(rightParenToken); first = false {
+		if !first && !p.consume(commaToken) {
+			return errors.New("expected comma")
+		}
+
+		if err := item(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// star parses a * or t.* with its EXCLUDE and REPLACE modifiers. It returns
+// null if the column is not a star.
+func (p *parser) star() (*starNode, error) {
+	start := p.index
+	star := &starNode{}
+	if p.expect(identifierToken) && p.index+2 < len(p.tokens) &&
+		p.tokens[p.index+1].tokType == dotToken && p.tokens[p.index+2].tokType == starToken {
+		star.qualifier = p.tokens[p.index].content
+		p.index += 2
+	}
+
+	if !p.consume(starToken) {
+		p.index = start
+		return nil, nil
+	}
+
+	for {
+		var err error
+		switch {
+		case p.consumeWord("EXCLUDE"):
+			err = p.parenthesized(func() error {
+				col, err := p.identifier("column to exclude")
+				if err != nil {
+					return err
+				}
+
+				// qualified names like t.col are kept as one name
+				if p.consume(dotToken) {
+					name, err := p.identifier("column to exclude")
+					if err != nil {
+						return err
+					}
+					col.content += "." + name.content
+				}
+
+				star.exclude = append(star.exclude, col.content)
+				return nil
+			})
+		case p.consumeWord("REPLACE"):
+			err = p.parenthesized(func() error {
+				expr, err := p.expr()
+				if err != nil {
+					return err
+				}
+
+				if !p.consume(asToken) {
+					return errors.New("expected AS in REPLACE")
+				}
+
+				col, err := p.identifier("column to replace")
+				if err != nil {
+					return err
+				}
+
+				star.replace = append(star.replace, &aliasNode{expr: expr, alias: col})
+				return nil
+			})
+		default:
+			return star, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// selectColumn parses a column of the select list with its alias.
+func (p *parser) selectColumn() (node, error) {
+	star, err := p.star()
+	if err != nil {
+		return nil, err
+	}
+	if star != nil {
+		return star, nil
+	}
+
+	colexpr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	// the alias can be given with AS or by just following the expression
+	if p.consume(asToken) || p.expect(identifierToken) {
+		alias, err := p.identifier("column alias")
+		if err != nil {
+			return nil, err
+		}
+		colexpr = &aliasNode{expr: colexpr, alias: alias}
+	}
+
+	return colexpr, nil
+}
+
+func (p *parser) pselect() (node, error) {
+	p.index = 0
+	sn, err := p.selectQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.index < len(p.tokens) {
+		return nil, errors.New("did not consume whole statement")
+	}
+
+	return sn, nil
+}
+
+// selectQuery parses a select starting at the current token, it is also the
+// source of an INSERT INTO ... SELECT.
+func (p *parser) selectQuery() (*selectNode, error) {
+	if !p.consume(selectToken) {
+		return nil, errors.New("expected select keyword")
+	}
+
+	sn := &selectNode{
+		distinct: p.consume(distinctToken),
+	}
+	for len(sn.columns) == 0 || p.consume(commaToken) {
+		colexpr, err := p.selectColumn()
+		if err != nil {
+			return nil, err
+		}
+
+		sn.columns = append(sn.columns, colexpr)
+	}
+
+	// without FROM the columns are evaluated once against an empty row
+	if p.consume(fromToken) {
+		from, err := p.from()
+		if err != nil {
+			return nil, err
+		}
+		sn.from = from
+
+		if p.consume(asOfSystemTimeToken) {
+			if sn.asOf, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if p.expect(whereToken) {
+		p.index++
+		whereexpr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+
+		sn.where = whereexpr
+	}
+
+	if p.consume(groupByToken) {
+		for len(sn.groupBy) == 0 || p.consume(commaToken) {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+
+			sn.groupBy = append(sn.groupBy, e)
+		}
+	}
+
+	if p.consume(havingToken) {
+		having, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+
+		sn.having = having
+	}
+
+	if p.consume(orderByToken) {
+		terms, err := p.orderBy()
+		if err != nil {
+			return nil, err
+		}
+
+		sn.orderBy = terms
+	}
+
+	if p.consume(limitToken) {
+		limit, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		sn.limit = limit
+
+		if p.consume(offsetToken) {
+			offset, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			sn.offset = offset
+		}
+	}
+
+	return sn, nil
+}
+
+// valuesRows parses the parenthesized rows of a VALUES list.
+func (p *parser) valuesRows() ([][]node, error) {
+	if !p.consume(valuesToken) {
+		return nil, errors.New("expected VALUES")
+	}
+
+	var rows [][]node
+	for len(rows) == 0 || p.consume(commaToken) {
+		if !p.consume(leftParenToken) {
+			return nil, errors.New("expected left paren")
+		}
+
+		var values []node
+		for len(values) == 0 || p.consume(commaToken) {
+			v, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, v)
+		}
+
+		if !p.consume(rightParenToken) {
+			return nil, errors.New("expected right paren")
+		}
+
+		if len(rows) > 0 && len(values) != len(rows[0]) {
+			return nil, errors.New("VALUES lists must all be the same length")
+		}
+		rows = append(rows, values)
+	}
+
+	return rows, nil
+}
+
+func (p *parser) values() (node, error) {
+	p.index = 0
+	rows, err := p.valuesRows()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.index < len(p.tokens) {
+		return nil, errors.New("did not consume whole statement")
+	}
+
+	return &valuesNode{rows: rows}, nil
+}
+
+// valuesRef parses a VALUES list in FROM, like (VALUES (1, 'a')) AS v (id, name).
+// The alias is required and the column names are optional.
+func (p *parser) valuesRef() (node, error) {
+	rows, err := p.valuesRows()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.consume(rightParenToken) {
+		return nil, errors.New("expected right paren after VALUES")
+	}
+
+	vn := &valuesNode{rows: rows}
+	p.consume(asToken)
+	if vn.alias, err = p.identifier("alias for VALUES"); err != nil {
+		return nil, err
+	}
+
+	if p.expect(leftParenToken) {
+		if vn.columns, err = p.identifierList("column name"); err != nil {
+			return nil, err
+		}
+
+		if len(vn.columns) > len(rows[0]) {
+			return nil, errors.New("more column names than VALUES has columns")
+		}
+	}
+
+	return vn, nil
+}
+
+func (p *parser) tableRef() (node, error) {
+	if p.consume(leftParenToken) {
+		return p.valuesRef()
+	}
+
+	tbl, err := p.identifier("table name")
+	if err != nil {
+		return nil, err
+	}
+
+	ref := &tableRefNode{table: tbl}
+	if p.consume(asToken) {
+		if ref.alias, err = p.identifier("alias after AS"); err != nil {
+			return nil, err
+		}
+	} else if p.expect(identifierToken) {
+		ref.alias = p.tokens[p.index]
+		p.index++
+	}
+
+	return ref, nil
 }
 
-func (p *parser) pselect() (node, error) {
-	p.index = 0
-	if !p.consume(selectToken) {
-		return nil, errors.New("expected select keyword")
+var joinKinds = map[int]joinKind{
+	joinToken:      innerJoin,
+	leftJoinToken:  leftJoin,
+	rightJoinToken: rightJoin,
+	fullJoinToken:  fullJoin,
+	crossJoinToken: crossJoin,
+}
+
+// from parses a table reference followed by any number of joins, which
+// associate to the left.
+func (p *parser) from() (node, error) {
+	from, err := p.tableRef()
+	if err != nil {
+		return nil, err
 	}
 
-	sn := &selectNode{}
-	for !p.expect(fromToken) {
-		if len(sn.columns) > 0 {
-			if !p.consume(commaToken) {
-				return nil, errors.New("expected comma")
-			}
+	for p.index < len(p.tokens) {
+		kind, ok := joinKinds[p.tokens[p.index].tokType]
+		if !ok {
+			break
 		}
+		p.index++
 
-		colexpr, err := p.expr()
+		right, err := p.tableRef()
 		if err != nil {
 			return nil, err
 		}
 
-		sn.columns = append(sn.columns, colexpr)
-	}
+		jn := &joinNode{kind: kind, left: from, right: right}
+		if kind != crossJoin {
+			if !p.consume(onToken) {
+				return nil, errors.New("expected ON after join")
+			}
 
-	if !p.consume(fromToken) {
-		return nil, errors.New("expected FROM")
-	}
+			if jn.on, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
 
-	if !p.expect(identifierToken) {
-		return nil, errors.New("expected FROM")
+		from = jn
 	}
-	sn.from = p.tokens[p.index]
-	p.index++
 
-	if p.expect(whereToken) {
-		p.index++
-		whereexpr, err := p.expr()
+	return from, nil
+}
+
+func (p *parser) orderBy() ([]orderByTerm, error) {
+	var terms []orderByTerm
+	for len(terms) == 0 || p.consume(commaToken) {
+		e, err := p.expr()
 		if err != nil {
 			return nil, err
 		}
 
-		sn.where = whereexpr
-	}
+		term := orderByTerm{expr: e}
+		if p.consume(descToken) {
+			term.desc = true
+		} else {
+			p.consume(ascToken)
+		}
 
-	if p.index < len(p.tokens) {
-		return nil, errors.New("did not consume whole statement")
+		if p.consume(nullsFirstToken) {
+			term.nulls = nullsFirst
+		} else if p.consume(nullsLastToken) {
+			term.nulls = nullsLast
+		}
+
+		terms = append(terms, term)
 	}
 
-	return sn, nil
+	return terms, nil
 }
 
 func (p *parser) createTable() (node, error) {
@@ -167,13 +801,26 @@ func (p *parser) createTable() (node, error) {
 		return nil, errors.New("expected opening paren")
 	}
 
-	for !p.expect(rightParenToken) {
-		if len(cols) > 0 {
+	// entries counts the columns and the table level primary key
+	for entries := 0; !p.expect(rightParenToken); entries++ {
+		if entries > 0 {
 			if !p.consume(commaToken) {
 				return nil, errors.New("expected comma")
 			}
 		}
 
+		if p.consume(primaryKeyToken) {
+			if len(cn.primaryKey) > 0 {
+				return nil, errors.New("multiple primary keys")
+			}
+
+			var err error
+			if cn.primaryKey, err = p.identifierList("primary key column"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
 		col := createTableColumn{}
 		if !p.expect(identifierToken) {
 			return nil, errors.New("expected identifier")
@@ -187,6 +834,13 @@ func (p *parser) createTable() (node, error) {
 		col.kind = p.tokens[p.index]
 		p.index++
 
+		if p.consume(primaryKeyToken) {
+			if len(cn.primaryKey) > 0 {
+				return nil, errors.New("multiple primary keys")
+			}
+			cn.primaryKey = []token{col.name}
+		}
+
 		cols = append(cols, col)
 	}
 
@@ -214,36 +868,335 @@ func (p *parser) insert() (node, error) {
 	}
 	p.index++
 
-	if !p.consume(valuesToken) {
-		return nil, errors.New("expected values token")
+	var err error
+	if p.expect(leftParenToken) {
+		if in.columns, err = p.identifierList("column name"); err != nil {
+			return nil, err
+		}
 	}
 
-	if !p.consume(leftParenToken) {
-		return nil, errors.New("expected left paren")
+	if p.expect(selectToken) {
+		in.query, err = p.selectQuery()
+	} else {
+		in.rows, err = p.valuesRows()
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	var values []node
-	for !p.expect(rightParenToken) {
-		if len(values) > 0 {
-			if !p.consume(commaToken) {
-				return nil, errors.New("expected comma")
-			}
+	if p.index < len(p.tokens) {
+		return nil, errors.New("did not parse whole token stream")
+	}
+	return in, nil
+}
+
+func (p *parser) update() (node, error) {
+	p.index = 0
+	if !p.consume(updateToken) {
+		return nil, errors.New("expected update keyword")
+	}
+
+	if !p.expect(identifierToken) {
+		return nil, errors.New("expected table name after update")
+	}
+
+	un := &updateNode{
+		table: p.tokens[p.index],
+	}
+	p.index++
+
+	if !p.consume(setToken) {
+		return nil, errors.New("expected set keyword")
+	}
+
+	for len(un.assignments) == 0 || p.consume(commaToken) {
+		if !p.expect(identifierToken) {
+			return nil, errors.New("expected column name")
+		}
+		assignment := updateAssignment{column: p.tokens[p.index]}
+		p.index++
+
+		if !p.consume(equalToken) {
+			return nil, errors.New("expected = after column name")
 		}
 
 		v, err := p.expr()
 		if err != nil {
 			return nil, err
 		}
+		assignment.value = v
+
+		un.assignments = append(un.assignments, assignment)
+	}
+
+	if p.consume(whereToken) {
+		whereexpr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+
+		un.where = whereexpr
+	}
+
+	if p.index < len(p.tokens) {
+		return nil, errors.New("did not consume whole statement")
+	}
+
+	return un, nil
+}
+
+func (p *parser) delete() (node, error) {
+	p.index = 0
+	if !p.consume(deleteToken) {
+		return nil, errors.New("expected delete from keyword")
+	}
+
+	if !p.expect(identifierToken) {
+		return nil, errors.New("expected table name after delete from")
+	}
+
+	dn := &deleteNode{
+		table: p.tokens[p.index],
+	}
+	p.index++
+
+	if p.consume(whereToken) {
+		whereexpr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+
+		dn.where = whereexpr
+	}
+
+	if p.index < len(p.tokens) {
+		return nil, errors.New("did not consume whole statement")
+	}
 
-		values = append(values, v)
+	return dn, nil
+}
+
+func (p *parser) dropTable() (node, error) {
+	p.index = 0
+	if !p.consume(dropTableToken) {
+		return nil, errors.New("expected drop table keyword")
+	}
+
+	dn := &dropTableNode{
+		ifExists: p.consume(ifExistsToken),
+	}
+
+	if !p.expect(identifierToken) {
+		return nil, errors.New("expected table name after drop table")
 	}
+	dn.table = p.tokens[p.index]
 	p.index++
 
 	if p.index < len(p.tokens) {
-		return nil, errors.New("did not parse whole token stream")
+		return nil, errors.New("did not consume whole statement")
 	}
-	in.values = values
-	return in, nil
+
+	return dn, nil
+}
+
+// identifierList parses a parenthesized list of names.
+func (p *parser) identifierList(what string) ([]token, error) {
+	if !p.consume(leftParenToken) {
+		return nil, errors.New("expected left paren before " + what)
+	}
+
+	var names []token
+	for len(names) == 0 || p.consume(commaToken) {
+		name, err := p.identifier(what)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	if !p.consume(rightParenToken) {
+		return nil, errors.New("expected right paren after " + what)
+	}
+	return names, nil
+}
+
+func (p *parser) createIndex() (node, error) {
+	p.index = 0
+	cn := &createIndexNode{}
+	if p.consume(createUniqueIndexToken) {
+		cn.unique = true
+	} else if !p.consume(createIndexToken) {
+		return nil, errors.New("expected create index keyword")
+	}
+
+	var err error
+	if cn.name, err = p.identifier("index name"); err != nil {
+		return nil, err
+	}
+
+	if !p.consume(onToken) {
+		return nil, errors.New("expected ON after index name")
+	}
+
+	if cn.table, err = p.identifier("table name"); err != nil {
+		return nil, err
+	}
+
+	if cn.columns, err = p.identifierList("index column"); err != nil {
+		return nil, err
+	}
+
+	if p.consumeWord("INCLUDE") {
+		if cn.include, err = p.identifierList("included column"); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.index < len(p.tokens) {
+		return nil, errors.New("did not consume whole statement")
+	}
+
+	return cn, nil
+}
+
+func (p *parser) dropIndex() (node, error) {
+	p.index = 0
+	if !p.consume(dropIndexToken) {
+		return nil, errors.New("expected drop index keyword")
+	}
+
+	dn := &dropIndexNode{
+		ifExists: p.consume(ifExistsToken),
+	}
+
+	var err error
+	if dn.name, err = p.identifier("index name after drop index"); err != nil {
+		return nil, err
+	}
+
+	if p.index < len(p.tokens) {
+		return nil, errors.New("did not consume whole statement")
+	}
+
+	return dn, nil
+}
+
+func (p *parser) truncateTable() (node, error) {
+	p.index = 0
+	if !p.consume(truncateTableToken) {
+		return nil, errors.New("expected truncate table keyword")
+	}
+
+	if !p.expect(identifierToken) {
+		return nil, errors.New("expected table name after truncate table")
+	}
+	tn := &truncateTableNode{
+		table: p.tokens[p.index],
+	}
+	p.index++
+
+	if p.index < len(p.tokens) {
+		return nil, errors.New("did not consume whole statement")
+	}
+
+	return tn, nil
+}
+
+// transaction parses BEGIN, COMMIT and ROLLBACK, each of which can be followed
+// by the word TRANSACTION. BEGIN can end in READ ONLY or READ WRITE.
+func (p *parser) transaction() (node, error) {
+	p.index = 0
+	if !p.expect(beginToken) && !p.expect(commitToken) && !p.expect(rollbackToken) {
+		return nil, errors.New("expected begin, commit or rollback keyword")
+	}
+
+	tn := &transactionNode{action: p.tokens[p.index].tokType}
+	p.index++
+	p.consumeWord("TRANSACTION")
+
+	if tn.action == beginToken && p.consumeWord("READ") {
+		tn.readOnly = p.consumeWord("ONLY")
+		if !tn.readOnly && !p.consumeWord("WRITE") {
+			return nil, errors.New("expected ONLY or WRITE after READ")
+		}
+	}
+
+	if p.index < len(p.tokens) {
+		return nil, errors.New("did not consume whole statement")
+	}
+
+	return tn, nil
+}
+
+func (p *parser) identifier(what string) (token, error) {
+	if !p.expect(identifierToken) {
+		return token{}, errors.New("expected " + what)
+	}
+
+	p.index++
+	return p.tokens[p.index-1], nil
+}
+
+func (p *parser) alterTable() (node, error) {
+	p.index = 0
+	if !p.consume(alterTableToken) {
+		return nil, errors.New("expected alter table keyword")
+	}
+
+	tbl, err := p.identifier("table name after alter table")
+	if err != nil {
+		return nil, err
+	}
+	an := &alterTableNode{table: tbl}
+
+	switch {
+	case p.consume(addColumnToken):
+		an.action = addColumnAction
+		if an.column, err = p.identifier("column name"); err != nil {
+			return nil, err
+		}
+
+		if an.kind, err = p.identifier("column type"); err != nil {
+			return nil, err
+		}
+
+		if p.consume(defaultToken) {
+			if an.def, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+	case p.consume(dropColumnToken):
+		an.action = dropColumnAction
+		if an.column, err = p.identifier("column name"); err != nil {
+			return nil, err
+		}
+	case p.consume(renameColumnToken):
+		an.action = renameColumnAction
+		if an.column, err = p.identifier("column name"); err != nil {
+			return nil, err
+		}
+
+		if !p.consume(toToken) {
+			return nil, errors.New("expected TO")
+		}
+
+		if an.newName, err = p.identifier("new column name"); err != nil {
+			return nil, err
+		}
+	case p.consume(renameToToken):
+		an.action = renameTableAction
+		if an.newName, err = p.identifier("new table name"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("expected ADD COLUMN, DROP COLUMN, RENAME COLUMN or RENAME TO")
+	}
+
+	if p.index < len(p.tokens) {
+		return nil, errors.New("did not consume whole statement")
+	}
+
+	return an, nil
 }
 
 func (p *parser) parse() (node, error) {
@@ -255,10 +1208,46 @@ func (p *parser) parse() (node, error) {
 		return p.createTable()
 	}
 
+	if p.expect(createIndexToken) || p.expect(createUniqueIndexToken) {
+		return p.createIndex()
+	}
+
+	if p.expect(dropIndexToken) {
+		return p.dropIndex()
+	}
+
 	if p.expect(insertToken) {
 		return p.insert()
 	}
 
+	if p.expect(updateToken) {
+		return p.update()
+	}
+
+	if p.expect(deleteToken) {
+		return p.delete()
+	}
+
+	if p.expect(dropTableToken) {
+		return p.dropTable()
+	}
+
+	if p.expect(truncateTableToken) {
+		return p.truncateTable()
+	}
+
+	if p.expect(alterTableToken) {
+		return p.alterTable()
+	}
+
+	if p.expect(valuesToken) {
+		return p.values()
+	}
+
+	if p.expect(beginToken) || p.expect(commitToken) || p.expect(rollbackToken) {
+		return p.transaction()
+	}
+
 	return nil, errors.New("unrecognized statement")
 }
 
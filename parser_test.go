@@ -1,6 +1,8 @@
 package levelsql
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -17,6 +19,18 @@ func TestParser_Expression(t *testing.T) {
 		// {"Concat expression", "first || ' ' || last_name", false, "first   last"},
 		{"Basic function call with a single arg", "test(10)", false, "test(10)"},
 		{"Basic function call with a single arg", "test(10, 20, 30)", false, "test(10, 20, 30)"},
+		{"Parentheses are kept where needed", "(1 + 2) * (3)", false, "(1 + 2) * 3"},
+		{"Right operand of the same precedence", "a - (b - c)", false, "a - (b - c)"},
+		{"Negated group", "NOT (a AND b) OR c", false, "NOT (a AND b) OR c"},
+		{"Unclosed parenthesis", "(1 + 2", true, ""},
+		{"Predicates", "a NOT BETWEEN 1 AND 2 AND b IN (1, 2)", false, "a NOT BETWEEN 1 AND 2 AND b IN (1, 2)"},
+		{"Empty IN list", "a IN ()", true, ""},
+		{"BETWEEN without AND", "a BETWEEN 1", true, ""},
+		{"GLOB with ESCAPE", "a GLOB 'x' ESCAPE 'y'", true, ""},
+		{"Searched CASE", "CASE WHEN a < 1 THEN 'low' WHEN a < 5 THEN 'mid' ELSE 'high' END", false, "CASE WHEN a < 1 THEN 'low' WHEN a < 5 THEN 'mid' ELSE 'high' END"},
+		{"Simple CASE", "CASE a + 1 WHEN 2 THEN b END || 'x'", false, "CASE a + 1 WHEN 2 THEN b END || 'x'"},
+		{"CASE without WHEN", "CASE a END", true, ""},
+		{"CASE without END", "CASE WHEN a THEN b", true, ""},
 	}
 
 	for _, tc := range tests {
@@ -40,12 +54,95 @@ func TestParser_Expression(t *testing.T) {
 			t.Fatalf("got error even though shouldnt: %s\n", err)
 		}
 
+		if tc.shouldErr {
+			continue
+		}
+
 		if exp.String() != tc.expectedString {
 			t.Fatalf("the resulting strings are not equal, got: %s | want: %s", exp.String(), tc.expectedString)
 		}
 	}
 }
 
+// grouped prints an expression with every operator in parentheses, so that the
+// tests can check how the parser grouped it.
+func grouped(n node) string {
+	switch n := n.(type) {
+	case *binopNode:
+		return fmt.Sprintf("(%s %s %s)", grouped(n.left), n.op.content, grouped(n.right))
+	case *unaryNode:
+		if n.op.tokType == notToken {
+			return fmt.Sprintf("(NOT %s)", grouped(n.operand))
+		}
+		return fmt.Sprintf("(%s%s)", n.op.content, grouped(n.operand))
+	case *inNode:
+		items := make([]string, len(n.list))
+		for i, item := range n.list {
+			items[i] = grouped(item)
+		}
+		return fmt.Sprintf("(%s%s IN (%s))", grouped(n.expr), notKeyword(n.not), strings.Join(items, ", "))
+	case *betweenNode:
+		return fmt.Sprintf("(%s%s BETWEEN %s AND %s)", grouped(n.expr), notKeyword(n.not), grouped(n.low), grouped(n.high))
+	case *likeNode:
+		s := fmt.Sprintf("%s%s %s %s", grouped(n.expr), notKeyword(n.not), n.op.content, grouped(n.pattern))
+		if n.escape != nil {
+			s += " ESCAPE " + grouped(n.escape)
+		}
+		return "(" + s + ")"
+	default:
+		return n.String()
+	}
+}
+
+func TestParser_Precedence(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1 + 2 = 3", "((1 + 2) = 3)"},
+		{"1 - 2 - 3", "((1 - 2) - 3)"},
+		{"1 + 2 * 3", "(1 + (2 * 3))"},
+		{"a * b / c % d", "(((a * b) / c) % d)"},
+		{"-a * -2", "((-a) * (-2))"},
+		{"a || b = c", "((a || b) = c)"},
+		{"a < b <> c >= d", "(((a < b) <> c) >= d)"},
+		{"lower(a) != 'x' - 1", "(lower(a) != ('x' - 1))"},
+		{"(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"a = 1 AND b < 5 OR c = 'x'", "(((a = 1) AND (b < 5)) OR (c = 'x'))"},
+		{"a = 1 AND (b < 5 OR c = 'x')", "((a = 1) AND ((b < 5) OR (c = 'x')))"},
+		{"a OR b AND c", "(a OR (b AND c))"},
+		{"NOT a = 1 AND b", "((NOT (a = 1)) AND b)"},
+		{"NOT NOT a", "(NOT (NOT a))"},
+		{"-(a + 1)", "(-(a + 1))"},
+		{"a IS NOT NULL AND b IS NULL", "((a IS NOT NULL) AND (b IS NULL))"},
+		{"NOT a IS DISTINCT FROM b + 1", "(NOT (a IS DISTINCT FROM (b + 1)))"},
+		{"a IS NOT DISTINCT FROM NULL", "(a IS NOT DISTINCT FROM NULL)"},
+		{"a BETWEEN 1 AND 2 + 3 AND b", "((a BETWEEN 1 AND (2 + 3)) AND b)"},
+		{"a NOT IN (1, 2 + 3) OR b", "((a NOT IN (1, (2 + 3))) OR b)"},
+		{"NOT a LIKE 'x%' ESCAPE '!'", "(NOT (a LIKE 'x%' ESCAPE '!'))"},
+		{"a || b NOT ILIKE c", "((a || b) NOT ILIKE c)"},
+		{"a GLOB '[a-c]*' = b", "((a GLOB '[a-c]*') = b)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer{content: tt.input}
+		p := parser{tokens: l.lex()}
+
+		exp, err := p.expr()
+		if err != nil {
+			t.Fatalf("%q: %s", tt.input, err)
+		}
+
+		if p.index != len(p.tokens) {
+			t.Fatalf("%q: only %d of %d tokens were parsed", tt.input, p.index, len(p.tokens))
+		}
+
+		if got := grouped(exp); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
 func TestParser_Select(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -55,6 +152,27 @@ func TestParser_Select(t *testing.T) {
 	}{
 		{"Basic select", "SELECT hello FROM world", false, "SELECT\n  hello\nFROM\n  world\n"},
 		{"Multiple columns", "SELECT id, name, age FROM users", false, "SELECT\n  id,\n  name,\n  age\nFROM\n  users\n"},
+		{"Order by", "SELECT id FROM users ORDER BY age DESC NULLS FIRST, id", false, "SELECT\n  id\nFROM\n  users\nORDER BY\n  age DESC NULLS FIRST,\n  id\n"},
+		{"Order by without terms", "SELECT id FROM users ORDER BY", true, ""},
+		{"Limit and offset", "SELECT id FROM users ORDER BY id LIMIT 10 OFFSET 20", false, "SELECT\n  id\nFROM\n  users\nORDER BY\n  id\nLIMIT 10\nOFFSET 20\n"},
+		{"Offset without limit", "SELECT id FROM users OFFSET 20", true, ""},
+		{"Join", "SELECT u.name, o.item FROM users AS u LEFT OUTER JOIN orders o ON u.id = o.uid CROSS JOIN tags", false, "SELECT\n  u.name,\n  o.item\nFROM\n  users AS u\n  LEFT JOIN orders AS o ON u.id = o.uid\n  CROSS JOIN tags\n"},
+		{"Join without ON", "SELECT name FROM users JOIN orders", true, ""},
+		{"Distinct", "SELECT DISTINCT id FROM users", false, "SELECT DISTINCT\n  id\nFROM\n  users\n"},
+		{"Group by and having", "SELECT region, count(DISTINCT name) FROM sales GROUP BY region HAVING count(*) = 2", false, "SELECT\n  region,\n  count(DISTINCT name)\nFROM\n  sales\nGROUP BY\n  region\nHAVING\ncount(*) = 2\n"},
+		{"Column aliases", "SELECT a + 1 AS b, lower(name) n FROM users", false, "SELECT\n  a + 1 AS b,\n  lower(name) AS n\nFROM\n  users\n"},
+		{"Alias without name", "SELECT a AS FROM users", true, ""},
+		{"Star", "SELECT *, u.* EXCLUDE (id, u.age) REPLACE (upper(name) AS name), o.* EXCLUDE uid FROM users u JOIN orders o ON u.id = o.uid", false, "SELECT\n  *,\n  u.* EXCLUDE (id, u.age) REPLACE (upper(name) AS name),\n  o.* EXCLUDE (uid)\nFROM\n  users AS u\n  JOIN orders AS o ON u.id = o.uid\n"},
+		{"REPLACE without AS", "SELECT * REPLACE (1 id) FROM users", true, ""},
+		{"Without FROM", "SELECT 1 + 1, upper('x') AS u", false, "SELECT\n  1 + 1,\n  upper('x') AS u\n"},
+		{"Without FROM with WHERE", "SELECT 1 WHERE 1 = 0", false, "SELECT\n  1\nWHERE\n1 = 0\n"},
+		{"Without columns", "SELECT FROM users", true, ""},
+		{"VALUES in FROM", "SELECT v.id FROM (VALUES (1, 'a'), (2, 'b')) AS v (id, name) JOIN users u ON u.id = v.id", false, "SELECT\n  v.id\nFROM\n  (VALUES (1, 'a'), (2, 'b')) AS v (id, name)\n  JOIN users AS u ON u.id = v.id\n"},
+		{"VALUES without alias", "SELECT column1 FROM (VALUES (1))", true, ""},
+		{"VALUES of different lengths", "SELECT * FROM (VALUES (1, 2), (3)) v", true, ""},
+		{"VALUES with too many names", "SELECT * FROM (VALUES (1)) v (a, b)", true, ""},
+		{"AS OF SYSTEM TIME", "SELECT name FROM users u AS OF SYSTEM TIME '-10s' WHERE u.id = 1", false, "SELECT\n  name\nFROM\n  users AS u\nAS OF SYSTEM TIME '-10s'\nWHERE\nu.id = 1\n"},
+		{"AS OF SYSTEM TIME without time", "SELECT name FROM users AS OF SYSTEM TIME", true, ""},
 	}
 
 	for _, tc := range tests {
@@ -78,6 +196,10 @@ func TestParser_Select(t *testing.T) {
 			t.Fatalf("got error even though shouldnt: %s\n", err)
 		}
 
+		if tc.shouldErr {
+			continue
+		}
+
 		if exp.String() != tc.expectedString {
 			t.Fatalf("the resulting strings are not equal, got: %s | want: %s", exp.String(), tc.expectedString)
 		}
@@ -94,6 +216,10 @@ func TestParser_CreateTable(t *testing.T) {
 		{"Basic CREATE TABLE", "CREATE TABLE users (id INTEGER, name TEXT)", false, "CREATE TABLE users (\nid INTEGER,\nname TEXT\n)\n"},
 		{"Single column", "CREATE TABLE numbers (value INTEGER)", false, "CREATE TABLE numbers (\nvalue INTEGER\n)\n"},
 		{"Multiple columns", "CREATE TABLE products (id INTEGER, name TEXT, price REAL, stock INTEGER)", false, "CREATE TABLE products (\nid INTEGER,\nname TEXT,\nprice REAL,\nstock INTEGER\n)\n"},
+		{"Column primary key", "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)", false, "CREATE TABLE users (\nid INTEGER,\nname TEXT,\nPRIMARY KEY (id)\n)\n"},
+		{"Composite primary key", "CREATE TABLE t (a INTEGER, b TEXT, PRIMARY KEY (b, a))", false, "CREATE TABLE t (\na INTEGER,\nb TEXT,\nPRIMARY KEY (b, a)\n)\n"},
+		{"Two primary keys", "CREATE TABLE t (a INTEGER PRIMARY KEY, b TEXT, PRIMARY KEY (b))", true, ""},
+		{"Primary key without columns", "CREATE TABLE t (a INTEGER, PRIMARY KEY ())", true, ""},
 	}
 
 	for _, tc := range tests {
@@ -124,8 +250,15 @@ func TestParser_Insert(t *testing.T) {
 		shouldErr      bool
 		expectedString string
 	}{
-		{"Basic INSERT", "INSERT INTO users VALUES(1, 'John')", false, "INSERT INTO users VALUES(1,John)\n"},
-		{"Multiple values", "INSERT INTO products VALUES(1, 'Laptop', 999, 50)", false, "INSERT INTO products VALUES(1,Laptop,999,50)\n"},
+		{"Basic INSERT", "INSERT INTO users VALUES(1, 'John')", false, "INSERT INTO users VALUES(1,'John')\n"},
+		{"Multiple values", "INSERT INTO products VALUES(1, 'Laptop', 999, 50)", false, "INSERT INTO products VALUES(1,'Laptop',999,50)\n"},
+		{"Multiple rows", "INSERT INTO users VALUES (1, 'a'), (2, 'b')", false, "INSERT INTO users VALUES(1,'a'),(2,'b')\n"},
+		{"Column list", "INSERT INTO t (c1, c3) VALUES (1, 2), (3, 4)", false, "INSERT INTO t (c1, c3) VALUES(1,2),(3,4)\n"},
+		{"Rows of different lengths", "INSERT INTO t VALUES (1, 2), (3)", true, ""},
+		{"Empty column list", "INSERT INTO t () VALUES (1)", true, ""},
+		{"Missing VALUES", "INSERT INTO t (c1)", true, ""},
+		{"Select", "INSERT INTO t (c1) SELECT id FROM users WHERE id > 1", false, "INSERT INTO t (c1) SELECT\n  id\nFROM\n  users\nWHERE\nid > 1\n"},
+		{"Select with trailing tokens", "INSERT INTO t SELECT id FROM users )", true, ""},
 	}
 
 	for _, tc := range tests {
@@ -149,6 +282,149 @@ func TestParser_Insert(t *testing.T) {
 	}
 }
 
+func TestParser_Update(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		shouldErr      bool
+		expectedString string
+	}{
+		{"Basic UPDATE", "UPDATE users SET name = 'John'", false, "UPDATE users SET\n  name = 'John'\n"},
+		{"Multiple assignments with where", "UPDATE users SET name = 'John', age = 30 WHERE id = 1", false, "UPDATE users SET\n  name = 'John',\n  age = 30\nWHERE\nid = 1\n"},
+		{"Missing SET", "UPDATE users name = 'John'", true, ""},
+		{"Missing assignment", "UPDATE users SET", true, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l := lexer{content: tc.input, index: 0}
+			tokens := l.lex()
+			p := parser{index: 0, tokens: tokens}
+
+			result, err := p.update()
+
+			if tc.shouldErr && err == nil {
+				t.Fatalf("Expected error, but got none")
+			}
+			if !tc.shouldErr && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if !tc.shouldErr && result.String() != tc.expectedString {
+				t.Fatalf("Expected:\n%s\nBut got:\n%s", tc.expectedString, result.String())
+			}
+		})
+	}
+}
+
+func TestParser_Delete(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		shouldErr      bool
+		expectedString string
+	}{
+		{"Basic DELETE", "DELETE FROM users", false, "DELETE FROM users\n"},
+		{"DELETE with where", "DELETE FROM users WHERE id = 1", false, "DELETE FROM users\nWHERE\nid = 1\n"},
+		{"Missing table", "DELETE FROM", true, ""},
+		{"Trailing tokens", "DELETE FROM users id", true, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l := lexer{content: tc.input, index: 0}
+			tokens := l.lex()
+			p := parser{index: 0, tokens: tokens}
+
+			result, err := p.delete()
+
+			if tc.shouldErr && err == nil {
+				t.Fatalf("Expected error, but got none")
+			}
+			if !tc.shouldErr && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if !tc.shouldErr && result.String() != tc.expectedString {
+				t.Fatalf("Expected:\n%s\nBut got:\n%s", tc.expectedString, result.String())
+			}
+		})
+	}
+}
+
+func TestParser_AlterTable(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		shouldErr      bool
+		expectedString string
+	}{
+		{"Add column", "ALTER TABLE users ADD COLUMN age INTEGER", false, "ALTER TABLE users ADD COLUMN age INTEGER\n"},
+		{"Add column with default", "ALTER TABLE users ADD COLUMN age INTEGER DEFAULT 18", false, "ALTER TABLE users ADD COLUMN age INTEGER DEFAULT 18\n"},
+		{"Drop column", "ALTER TABLE users DROP COLUMN age", false, "ALTER TABLE users DROP COLUMN age\n"},
+		{"Rename column", "ALTER TABLE users RENAME COLUMN age TO years", false, "ALTER TABLE users RENAME COLUMN age TO years\n"},
+		{"Rename table", "ALTER TABLE users RENAME TO people", false, "ALTER TABLE users RENAME TO people\n"},
+		{"Add column without type", "ALTER TABLE users ADD COLUMN age", true, ""},
+		{"Rename column without TO", "ALTER TABLE users RENAME COLUMN age years", true, ""},
+		{"Missing action", "ALTER TABLE users", true, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l := lexer{content: tc.input, index: 0}
+			tokens := l.lex()
+			p := parser{index: 0, tokens: tokens}
+
+			result, err := p.alterTable()
+
+			if tc.shouldErr && err == nil {
+				t.Fatalf("Expected error, but got none")
+			}
+			if !tc.shouldErr && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if !tc.shouldErr && result.String() != tc.expectedString {
+				t.Fatalf("Expected:\n%s\nBut got:\n%s", tc.expectedString, result.String())
+			}
+		})
+	}
+}
+
+func TestParser_CreateIndex(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		shouldErr      bool
+		expectedString string
+	}{
+		{"Index", "CREATE INDEX users_age ON users (age)", false, "CREATE INDEX users_age ON users (age)\n"},
+		{"Unique index", "CREATE UNIQUE INDEX users_name ON users (last, first)", false, "CREATE UNIQUE INDEX users_name ON users (last, first)\n"},
+		{"Covering index", "CREATE INDEX users_age ON users (age) INCLUDE (name, id)", false, "CREATE INDEX users_age ON users (age) INCLUDE (name, id)\n"},
+		{"Missing ON", "CREATE INDEX users_age users (age)", true, ""},
+		{"Missing columns", "CREATE INDEX users_age ON users", true, ""},
+		{"Empty column list", "CREATE INDEX users_age ON users ()", true, ""},
+		{"Empty include list", "CREATE INDEX users_age ON users (age) INCLUDE ()", true, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			l := lexer{content: tc.input, index: 0}
+			tokens := l.lex()
+			p := parser{index: 0, tokens: tokens}
+
+			result, err := p.createIndex()
+
+			if tc.shouldErr && err == nil {
+				t.Fatalf("Expected error, but got none")
+			}
+			if !tc.shouldErr && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if !tc.shouldErr && result.String() != tc.expectedString {
+				t.Fatalf("Expected:\n%s\nBut got:\n%s", tc.expectedString, result.String())
+			}
+		})
+	}
+}
+
 func TestParser_Parse(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -158,7 +434,26 @@ func TestParser_Parse(t *testing.T) {
 		{"Valid SELECT", "SELECT name FROM users", false},
 		{"Valid CREATE TABLE", "CREATE TABLE products (id INTEGER, name TEXT)", false},
 		{"Valid INSERT", "INSERT INTO users VALUES(1, 'Alice')", false},
-		{"Invalid statement", "DELETE FROM users", true},
+		{"Valid UPDATE", "UPDATE users SET name = 'Alice' WHERE id = 1", false},
+		{"Valid DELETE", "DELETE FROM users WHERE id = 1", false},
+		{"Valid DROP TABLE", "DROP TABLE users", false},
+		{"Valid DROP TABLE IF EXISTS", "DROP TABLE IF EXISTS users", false},
+		{"Valid TRUNCATE TABLE", "TRUNCATE TABLE users", false},
+		{"Valid CREATE INDEX", "CREATE INDEX users_age ON users (age)", false},
+		{"Valid DROP INDEX", "DROP INDEX users_age", false},
+		{"Valid DROP INDEX IF EXISTS", "DROP INDEX IF EXISTS users_age", false},
+		{"DROP TABLE without name", "DROP TABLE", true},
+		{"DROP INDEX without name", "DROP INDEX", true},
+		{"Valid BEGIN", "BEGIN", false},
+		{"Valid BEGIN TRANSACTION", "BEGIN TRANSACTION", false},
+		{"Valid COMMIT", "COMMIT", false},
+		{"Valid ROLLBACK TRANSACTION", "rollback transaction", false},
+		{"BEGIN with trailing tokens", "BEGIN users", true},
+		{"Valid BEGIN READ ONLY", "BEGIN READ ONLY", false},
+		{"Valid BEGIN TRANSACTION READ WRITE", "BEGIN TRANSACTION READ WRITE", false},
+		{"BEGIN READ without mode", "BEGIN READ", true},
+		{"COMMIT READ ONLY", "COMMIT READ ONLY", true},
+		{"Invalid statement", "VACUUM users", true},
 	}
 
 	for _, tc := range tests {
@@ -0,0 +1,595 @@
+package levelsql
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// rowSource is a planned input of a select. Every call to open starts a new scan
+// over the rows, which lets a nested loop join rescan its inner side.
+type rowSource interface {
+	schema() *table
+	open() (storageIterator, error)
+
+	// size is a rough estimate of the bytes the rows take, it is only used to
+	// compare inputs with each other.
+	size() int64
+}
+
+// orderedSource is a row source whose rows come sorted on one of its columns in
+// the order of compareValues. orderedBy returns the index of that column or -1.
+type orderedSource interface {
+	rowSource
+	orderedBy() int
+}
+
+// tableScan reads the rows of a stored table. A scan restricted on the primary
+// key reads the single row stored under point or the rows in [start, limit).
+type tableScan struct {
+	storage storage
+	name    string
+	table   *table // columns are qualified with the alias if there is one
+
+	point        []byte
+	start, limit []byte
+
+	asOf uint64 // the commit timestamp to read the rows at, 0 reads the newest
+}
+
+func (ts *tableScan) schema() *table {
+	return ts.table
+}
+
+// rows of a table with a primary key are stored in key order, which sorts them
+// on the first key column.
+func (ts *tableScan) orderedBy() int {
+	if pk := ts.table.primaryKey(); len(pk) > 0 {
+		return pk[0]
+	}
+	return -1
+}
+
+func (ts *tableScan) size() int64 {
+	size, err := ts.storage.tableSize(ts.name)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+func (ts *tableScan) open() (storageIterator, error) {
+	var iter storageIterator
+	var err error
+	readAt := latestTimestamp
+	if ts.asOf != 0 {
+		readAt = ts.asOf
+	}
+
+	switch {
+	case ts.point != nil:
+		var r *row
+		r, err = ts.storage.getRow(ts.name, ts.point, readAt)
+		iter = &singleRowIterator{row: r}
+	case ts.start != nil:
+		iter, err = ts.storage.getRowRange(ts.name, ts.start, ts.limit, readAt)
+	case ts.asOf == 0:
+		iter, err = ts.storage.getRowIterator(ts.name)
+	default:
+		r := util.BytesPrefix(rowPrefix(ts.table))
+		iter, err = ts.storage.getRowRange(ts.name, r.Start, r.Limit, readAt)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get row iterator: %s", err)
+	}
+
+	if ts.table.Name == ts.name {
+		return iter, nil
+	}
+	return &aliasIterator{storageIterator: iter, table: ts.table}, nil
+}
+
+// valuesScan returns rows that were evaluated while planning. It is the source of
+// a VALUES list in FROM and of a select without FROM, which reads a single empty
+// row.
+type valuesScan struct {
+	table *table
+	rows  [][]value
+}
+
+func (vs *valuesScan) schema() *table {
+	return vs.table
+}
+
+func (vs *valuesScan) size() int64 {
+	var size int64
+	for _, r := range vs.rows {
+		for _, v := range r {
+			size += int64(len(v.bytes()))
+		}
+	}
+	return size
+}
+
+func (vs *valuesScan) open() (storageIterator, error) {
+	return &valuesIterator{scan: vs}, nil
+}
+
+type valuesIterator struct {
+	scan *valuesScan
+	next int
+}
+
+func (vi *valuesIterator) Next() (*row, bool) {
+	if vi.next >= len(vi.scan.rows) {
+		return nil, false
+	}
+
+	r := newRow(vi.scan.table)
+	r.Cells = append(r.Cells, vi.scan.rows[vi.next]...)
+	vi.next++
+	return r, true
+}
+
+func (vi *valuesIterator) Close() error {
+	return nil
+}
+
+var valueTypeNames = [...]string{
+	boolVal:    "bool",
+	stringVal:  "string",
+	integerVal: "integer",
+}
+
+// planValues evaluates the rows of a VALUES list. Every column gets the type of
+// its first value that isn't NULL.
+func (e *exec) planValues(vn *valuesNode) (*valuesScan, error) {
+	names := vn.columnNames()
+	vs := &valuesScan{
+		table: &table{Name: vn.alias.content, Columns: names, Types: make([]string, len(names))},
+	}
+
+	emptyRow := &row{}
+	for _, exprs := range vn.rows {
+		vals, err := executeArgs(e, emptyRow, exprs)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, v := range vals {
+			if vs.table.Types[i] == "" && v.ty != nullVal {
+				vs.table.Types[i] = valueTypeNames[v.ty]
+			}
+		}
+		vs.rows = append(vs.rows, vals)
+	}
+
+	return vs, nil
+}
+
+// keyBounds is the part of a key space that the conditions of a where clause
+// can match. equal counts the leading key columns that are fixed by equality and
+// bounded tells if the column after them has a range.
+type keyBounds struct {
+	point        []byte
+	start, limit []byte
+	equal        int
+	bounded      bool
+}
+
+// better reports if a scan with kb reads fewer keys than one with other is
+// expected to.
+func (kb keyBounds) better(other keyBounds) bool {
+	if kb.equal != other.equal {
+		return kb.equal > other.equal
+	}
+	return kb.bounded && !other.bounded
+}
+
+// keyBounds narrows the keys within prefix that start with the columns at idxs.
+// Equality on the leading columns fixes that part of the key and a range on the
+// column after them bounds the scan.
+func (e *exec) keyBounds(tbl *table, idxs []int, conds []node, prefix []byte) keyBounds {
+	for i, idx := range idxs {
+		if v, ok := e.keyEquality(tbl, idx, conds); ok {
+			prefix = appendKeyValue(prefix, v)
+			continue
+		}
+
+		start, limit, bounded := e.keyRange(tbl, idx, conds, prefix)
+		kb := keyBounds{equal: i, bounded: bounded}
+		if i > 0 || bounded {
+			kb.start, kb.limit = start, limit
+		}
+		return kb
+	}
+
+	return keyBounds{point: prefix, equal: len(idxs)}
+}
+
+// restrict picks how the rows of the scan are read. The primary key and every
+// index are narrowed to the keys the where clause can match and the one fixing
+// the most columns is used, with the primary key winning ties. An index is only
+// read if it narrows the scan. used are the expressions that read the rows, the
+// rows are built from the index entries alone if those hold every column they
+// refer to. The where clause still has to be checked for every row that is read.
+func (ts *tableScan) restrict(e *exec, where node, used []node) rowSource {
+	if where == nil {
+		return ts
+	}
+
+	conds := conjuncts(where)
+	best := keyBounds{}
+	if pk := ts.table.primaryKey(); len(pk) > 0 {
+		best = e.keyBounds(ts.table, pk, conds, rowPrefix(ts.table))
+	}
+
+	// the index entries only exist for the newest rows
+	var chosen *index
+	for i := 0; ts.asOf == 0 && i < len(ts.table.Indexes); i++ {
+		ix := &ts.table.Indexes[i]
+		kb := e.keyBounds(ts.table, ts.table.positions(ix.Columns), conds, indexPrefix(ts.table, ix.ID))
+		if kb.better(best) {
+			best, chosen = kb, ix
+		}
+	}
+
+	if chosen == nil {
+		ts.point, ts.start, ts.limit = best.point, best.start, best.limit
+		return ts
+	}
+
+	is := &indexScan{tableScan: ts, index: chosen, start: best.start, limit: best.limit}
+	if best.point != nil {
+		is.start, is.limit = best.point, util.BytesPrefix(best.point).Limit
+	}
+	is.covering = used != nil && covers(ts.table, indexColumns(chosen), append(used, where))
+	return is
+}
+
+// covers reports if every column that the expressions refer to is one of the
+// columns with the given ids.
+func covers(tbl *table, ids []int, exprs []node) bool {
+	held := make(map[int]bool, len(ids))
+	for _, idx := range tbl.positions(ids) {
+		held[idx] = true
+	}
+
+	covered := true
+	for _, expr := range exprs {
+		walk(expr, func(n node) bool {
+			lit, ok := n.(*literalNode)
+			if ok && lit.lit.tokType == identifierToken {
+				// names that aren't columns don't read the row
+				if idx := tbl.fieldIndex(lit.lit.content); idx != -1 && !held[idx] {
+					covered = false
+				}
+			}
+			return covered
+		})
+	}
+	return covered
+}
+
+// indexScan reads the rows of a table through the entries of an index with keys
+// in [start, limit). A covering scan builds the rows from the values stored in
+// the entries, every column that isn't in the index is NULL.
+type indexScan struct {
+	*tableScan
+	index        *index
+	start, limit []byte
+	covering     bool
+}
+
+func (is *indexScan) open() (storageIterator, error) {
+	iter, err := is.storage.getIndexRange(is.name, is.index, is.start, is.limit, is.covering)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get index iterator: %s", err)
+	}
+
+	if is.table.Name == is.name {
+		return iter, nil
+	}
+	return &aliasIterator{storageIterator: iter, table: is.table}, nil
+}
+
+// rows read through an index aren't in key order
+func (is *indexScan) orderedBy() int {
+	return -1
+}
+
+// keyConstant returns the value of n if it doesn't depend on the row.
+func (e *exec) keyConstant(n node, tbl *table) (value, bool) {
+	if columnSide(n, tbl, &table{}) != 0 {
+		return value{}, false
+	}
+
+	// an error is left for the where clause to report, and a comparison
+	// with NULL can't be turned into a key.
+	v, err := e.executeExpression(n, &row{})
+	if err != nil || v.ty == nullVal {
+		return value{}, false
+	}
+	return v, true
+}
+
+// flippedComparisons maps the comparisons that can bound a key to the operator
+// with the operands swapped.
+var flippedComparisons = map[int]int{
+	equalToken: equalToken,
+	ltToken:    gtToken,
+	leToken:    geToken,
+	gtToken:    ltToken,
+	geToken:    leToken,
+}
+
+// keyComparison matches a condition like column op constant, where the column
+// is the one at idx. The operator is flipped when the constant comes first.
+func (e *exec) keyComparison(tbl *table, idx int, cond node) (int, value, bool) {
+	bn, ok := cond.(*binopNode)
+	if !ok {
+		return 0, value{}, false
+	}
+
+	op, ok := flippedComparisons[bn.op.tokType]
+	if !ok {
+		return 0, value{}, false
+	}
+
+	if isColumn(bn.left, tbl, idx) {
+		v, ok := e.keyConstant(bn.right, tbl)
+		return bn.op.tokType, v, ok
+	}
+
+	if isColumn(bn.right, tbl, idx) {
+		v, ok := e.keyConstant(bn.left, tbl)
+		return op, v, ok
+	}
+	return 0, value{}, false
+}
+
+func isColumn(n node, tbl *table, idx int) bool {
+	lit, ok := n.(*literalNode)
+	return ok && lit.lit.tokType == identifierToken && tbl.fieldIndex(lit.lit.content) == idx
+}
+
+func (e *exec) keyEquality(tbl *table, idx int, conds []node) (value, bool) {
+	for _, cond := range conds {
+		if op, v, ok := e.keyComparison(tbl, idx, cond); ok && op == equalToken {
+			return v, true
+		}
+	}
+	return value{}, false
+}
+
+// keyRange returns the tightest key range within prefix that the comparisons of
+// the column at idx allow. bounded is false if there are none.
+func (e *exec) keyRange(tbl *table, idx int, conds []node, prefix []byte) (start, limit []byte, bounded bool) {
+	start, limit = prefix, util.BytesPrefix(prefix).Limit
+
+	lower := func(key []byte) {
+		if bytes.Compare(key, start) > 0 {
+			start = key
+		}
+		bounded = true
+	}
+	upper := func(key []byte) {
+		if limit == nil || bytes.Compare(key, limit) < 0 {
+			limit = key
+		}
+		bounded = true
+	}
+
+	// the keys of every row with column = v start with prefix + v
+	apply := func(op int, v value) {
+		key := appendKeyValue(append([]byte(nil), prefix...), v)
+		switch op {
+		case gtToken:
+			lower(util.BytesPrefix(key).Limit)
+		case geToken:
+			lower(key)
+		case ltToken:
+			upper(key)
+		case leToken:
+			upper(util.BytesPrefix(key).Limit)
+		}
+	}
+
+	for _, cond := range conds {
+		if ln, ok := cond.(*likeNode); ok && !ln.not && isColumn(ln.expr, tbl, idx) {
+			if p, ok := likePrefix(ln); ok {
+				// the key of every string starting with p starts with the
+				// key of p without its terminator. Other types sort before
+				// the strings and can only be skipped if their text can't
+				// match.
+				key := appendKeyValue(append([]byte(nil), prefix...), value{ty: stringVal, stringVal: p})
+				key = key[:len(key)-2]
+				upper(util.BytesPrefix(key).Limit)
+				if !startsNonString(p) {
+					lower(key)
+				}
+			}
+			continue
+		}
+
+		if bn, ok := cond.(*betweenNode); ok && !bn.not && isColumn(bn.expr, tbl, idx) {
+			if v, ok := e.keyConstant(bn.low, tbl); ok {
+				apply(geToken, v)
+			}
+			if v, ok := e.keyConstant(bn.high, tbl); ok {
+				apply(leToken, v)
+			}
+			continue
+		}
+
+		if op, v, ok := e.keyComparison(tbl, idx, cond); ok {
+			apply(op, v)
+		}
+	}
+
+	return start, limit, bounded
+}
+
+// singleRowIterator returns the row of a point lookup, a nil row means there was
+// no match.
+type singleRowIterator struct {
+	row *row
+}
+
+func (si *singleRowIterator) Next() (*row, bool) {
+	r := si.row
+	si.row = nil
+	return r, r != nil
+}
+
+func (si *singleRowIterator) Close() error {
+	if si.row != nil {
+		si.row.Release()
+		si.row = nil
+	}
+	return nil
+}
+
+// aliasIterator makes the rows of a table scan resolve qualified columns with
+// the alias of the table.
+type aliasIterator struct {
+	storageIterator
+	table *table
+}
+
+func (ai *aliasIterator) Next() (*row, bool) {
+	r, ok := ai.storageIterator.Next()
+	if ok {
+		r.table = ai.table
+	}
+	return r, ok
+}
+
+// columnMatches reports whether a possibly qualified name like "u.id" refers
+// to the column at index i.
+func columnMatches(schema *table, i int, name string) bool {
+	if j := strings.LastIndexByte(name, '.'); j != -1 {
+		return schema.qualifier(i) == name[:j] && schema.Columns[i] == name[j+1:]
+	}
+	return schema.Columns[i] == name
+}
+
+// expandStars replaces the stars in the select list with the columns of the
+// FROM clause they stand for. The columns are referenced by their qualified name,
+// so the same column name from both sides of a join can't be mixed up.
+func expandStars(columns []node, schema *table) ([]node, error) {
+	var expanded []node
+	for _, col := range columns {
+		star, ok := col.(*starNode)
+		if !ok {
+			expanded = append(expanded, col)
+			continue
+		}
+
+		// every name in EXCLUDE and REPLACE has to match a column of the star
+		used := make(map[string]bool)
+		found := false
+		for i, name := range schema.Columns {
+			if star.qualifier != "" && schema.qualifier(i) != star.qualifier {
+				continue
+			}
+			found = true
+
+			excluded := false
+			for _, ex := range star.exclude {
+				if columnMatches(schema, i, ex) {
+					used[ex], excluded = true, true
+				}
+			}
+			if excluded {
+				continue
+			}
+
+			var column node = &literalNode{lit: token{
+				tokType: identifierToken,
+				content: schema.qualifier(i) + "." + name,
+			}}
+			for _, r := range star.replace {
+				if r.alias.content == name {
+					used[r.alias.content] = true
+					column = r
+				}
+			}
+
+			expanded = append(expanded, column)
+		}
+
+		if !found {
+			if star.qualifier != "" {
+				return nil, fmt.Errorf("no such table in FROM: %s", star.qualifier)
+			}
+			return nil, errors.New("* needs a FROM clause with columns")
+		}
+
+		for _, ex := range star.exclude {
+			if !used[ex] {
+				return nil, fmt.Errorf("column in EXCLUDE not found: %s", ex)
+			}
+		}
+		for _, r := range star.replace {
+			if !used[r.alias.content] {
+				return nil, fmt.Errorf("column in REPLACE not found: %s", r.alias.content)
+			}
+		}
+	}
+
+	return expanded, nil
+}
+
+// planTableScan returns a scan of the rows of a table that the where clause can
+// match, UPDATE and DELETE find their rows with it.
+func (e *exec) planTableScan(name string, where node) (rowSource, error) {
+	tbl, err := e.storage.getTable(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get table: %s", err)
+	}
+
+	ts := &tableScan{storage: e.storage, name: name, table: tbl}
+	return ts.restrict(e, where, nil), nil
+}
+
+// planFrom turns the FROM clause of a select into a row source. The tables are
+// read as of the commit timestamp asOf, 0 reads the newest rows.
+func (e *exec) planFrom(from node, asOf uint64) (rowSource, error) {
+	switch n := from.(type) {
+	case nil:
+		return &valuesScan{table: &table{}, rows: [][]value{nil}}, nil
+	case *valuesNode:
+		return e.planValues(n)
+	case *tableRefNode:
+		tbl, err := e.storage.getTable(n.table.content)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get table: %s", err)
+		}
+
+		if n.alias.content != "" {
+			aliased := *tbl
+			aliased.Name = n.alias.content
+			tbl = &aliased
+		}
+
+		return &tableScan{storage: e.storage, name: n.table.content, table: tbl, asOf: asOf}, nil
+	case *joinNode:
+		left, err := e.planFrom(n.left, asOf)
+		if err != nil {
+			return nil, err
+		}
+
+		right, err := e.planFrom(n.right, asOf)
+		if err != nil {
+			return nil, err
+		}
+
+		return e.planJoin(n, left, right)
+	default:
+		return nil, errors.New("unsupported FROM clause")
+	}
+}
@@ -0,0 +1,337 @@
+package levelsql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+func (e *exec) executeIn(in *inNode, row *row) (value, error) {
+	v, err := e.executeExpression(in.expr, row)
+	if err != nil || v.ty == nullVal {
+		return value{ty: nullVal}, err
+	}
+
+	// without a match a NULL in the list makes the result unknown, it could
+	// have been equal.
+	sawNull := false
+	for _, item := range in.list {
+		iv, err := e.executeExpression(item, row)
+		if err != nil {
+			return value{}, err
+		}
+
+		if iv.ty == nullVal {
+			sawNull = true
+			continue
+		}
+
+		if compareValues(v, iv) == 0 {
+			return boolValue(!in.not, true), nil
+		}
+	}
+
+	return boolValue(in.not, !sawNull), nil
+}
+
+// executeCase evaluates the WHEN conditions in order and only evaluates the
+// result of the first one that holds. A simple CASE compares its operand with =,
+// so a NULL operand never matches.
+func (e *exec) executeCase(cn *caseNode, row *row) (value, error) {
+	var operand value
+	if cn.operand != nil {
+		var err error
+		if operand, err = e.executeExpression(cn.operand, row); err != nil {
+			return value{}, err
+		}
+	}
+
+	for _, w := range cn.whens {
+		cond, err := e.executeExpression(w.cond, row)
+		if err != nil {
+			return value{}, err
+		}
+
+		matched := cond.asBool()
+		if cn.operand != nil {
+			matched = operand.ty != nullVal && cond.ty != nullVal && compareValues(operand, cond) == 0
+		}
+
+		if matched {
+			return e.executeExpression(w.result, row)
+		}
+	}
+
+	if cn.elseExpr == nil {
+		return value{ty: nullVal}, nil
+	}
+	return e.executeExpression(cn.elseExpr, row)
+}
+
+func (e *exec) executeBetween(bn *betweenNode, row *row) (value, error) {
+	vals, err := executeArgs(e, row, []node{bn.expr, bn.low, bn.high})
+	if err != nil {
+		return value{}, err
+	}
+
+	// the result is low <= expr AND expr <= high, where a NULL only makes the
+	// result unknown if the other bound doesn't rule the value out.
+	v, low, high := vals[0], vals[1], vals[2]
+	aboveLow := v.ty == nullVal || low.ty == nullVal || compareValues(v, low) >= 0
+	belowHigh := v.ty == nullVal || high.ty == nullVal || compareValues(v, high) <= 0
+	if !aboveLow || !belowHigh {
+		return boolValue(bn.not, true), nil
+	}
+
+	if hasNull(vals) {
+		return value{ty: nullVal}, nil
+	}
+	return boolValue(!bn.not, true), nil
+}
+
+func (e *exec) executeLike(ln *likeNode, row *row) (value, error) {
+	args := []node{ln.expr, ln.pattern}
+	if ln.escape != nil {
+		args = append(args, ln.escape)
+	}
+
+	vals, err := executeArgs(e, row, args)
+	if err != nil {
+		return value{}, err
+	}
+
+	if hasNull(vals) {
+		return value{ty: nullVal}, nil
+	}
+
+	s, pattern, escape := vals[0].asStr(), vals[1].asStr(), ""
+	if ln.escape != nil {
+		escape = vals[2].asStr()
+		if utf8.RuneCountInString(escape) != 1 {
+			return value{}, fmt.Errorf("ESCAPE must be a single character, got: %q", escape)
+		}
+	}
+
+	if ln.op.tokType == ilikeToken {
+		s, pattern, escape = strings.ToLower(s), strings.ToLower(pattern), strings.ToLower(escape)
+	}
+
+	elems, err := ln.compile(pattern, escape)
+	if err != nil {
+		return value{}, err
+	}
+
+	return boolValue(matchPattern(elems, []rune(s)) != ln.not, true), nil
+}
+
+// compile returns the compiled pattern, the last one is reused if the pattern
+// didn't change.
+func (ln *likeNode) compile(pattern, escape string) ([]patternElem, error) {
+	key := escape + "\x00" + pattern
+	if ln.compiled != nil && ln.compiledFor == key {
+		return ln.compiled, nil
+	}
+
+	var elems []patternElem
+	var err error
+	if ln.op.tokType == globToken {
+		elems, err = compileGlob(pattern)
+	} else {
+		elems, err = compileLike(pattern, escape)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ln.compiled, ln.compiledFor = elems, key
+	return elems, nil
+}
+
+// likePrefix returns the constant text every match of the pattern starts with.
+// A case sensitive pattern with a non-empty prefix only matches the strings that
+// start with it, which keyRange turns into a range of keys.
+func likePrefix(ln *likeNode) (string, bool) {
+	if ln.op.tokType == ilikeToken {
+		return "", false
+	}
+
+	pattern, ok := ln.pattern.(*literalNode)
+	if !ok || pattern.lit.tokType != stringToken {
+		return "", false
+	}
+
+	escape := ""
+	if ln.escape != nil {
+		lit, ok := ln.escape.(*literalNode)
+		if !ok || lit.lit.tokType != stringToken {
+			return "", false
+		}
+		escape = lit.lit.content
+		if utf8.RuneCountInString(escape) != 1 {
+			return "", false
+		}
+	}
+
+	elems, err := ln.compile(pattern.lit.content, escape)
+	if err != nil {
+		return "", false
+	}
+
+	var prefix strings.Builder
+	for _, elem := range elems {
+		if elem.kind != patLiteral {
+			break
+		}
+		prefix.WriteRune(elem.r)
+	}
+	return prefix.String(), prefix.Len() > 0
+}
+
+// startsNonString reports whether the text of an integer or a boolean could
+// start with prefix, LIKE matches the values of every type by their text.
+func startsNonString(prefix string) bool {
+	for _, s := range []string{"true", "false"} {
+		if strings.HasPrefix(s, prefix) || strings.HasPrefix(prefix, s) {
+			return true
+		}
+	}
+	return strings.IndexByte("-0123456789", prefix[0]) != -1
+}
+
+const (
+	patLiteral = iota
+	patAny     // a single character
+	patSeq     // any sequence of characters
+	patClass   // a GLOB character class like [a-z]
+)
+
+type patternElem struct {
+	kind   int
+	r      rune
+	ranges [][2]rune
+	negate bool
+}
+
+func (pe *patternElem) matches(c rune) bool {
+	switch pe.kind {
+	case patLiteral:
+		return c == pe.r
+	case patAny:
+		return true
+	case patClass:
+		in := false
+		for _, rng := range pe.ranges {
+			if c >= rng[0] && c <= rng[1] {
+				in = true
+				break
+			}
+		}
+		return in != pe.negate
+	}
+	return false
+}
+
+func appendSeq(elems []patternElem) []patternElem {
+	if len(elems) > 0 && elems[len(elems)-1].kind == patSeq {
+		return elems
+	}
+	return append(elems, patternElem{kind: patSeq})
+}
+
+// compileLike compiles a LIKE pattern where % matches any sequence and _ any
+// single character. A character after the escape character is always literal.
+func compileLike(pattern, escape string) ([]patternElem, error) {
+	esc, _ := utf8.DecodeRuneInString(escape)
+
+	var elems []patternElem
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case escape != "" && r == esc:
+			i++
+			if i >= len(runes) {
+				return nil, errors.New("LIKE pattern must not end with the escape character")
+			}
+			elems = append(elems, patternElem{kind: patLiteral, r: runes[i]})
+		case r == '%':
+			elems = appendSeq(elems)
+		case r == '_':
+			elems = append(elems, patternElem{kind: patAny})
+		default:
+			elems = append(elems, patternElem{kind: patLiteral, r: r})
+		}
+	}
+	return elems, nil
+}
+
+// compileGlob compiles a GLOB pattern where * matches any sequence, ? any
+// single character and [...] a character class. A class starting with ^ is
+// negated and a ] right after the opening bracket is part of the class.
+func compileGlob(pattern string) ([]patternElem, error) {
+	var elems []patternElem
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*':
+			elems = appendSeq(elems)
+		case '?':
+			elems = append(elems, patternElem{kind: patAny})
+		case '[':
+			class := patternElem{kind: patClass}
+			i++
+			if i < len(runes) && runes[i] == '^' {
+				class.negate = true
+				i++
+			}
+
+			start := i
+			for ; i < len(runes) && (runes[i] != ']' || i == start); i++ {
+				lo, hi := runes[i], runes[i]
+				if i+2 < len(runes) && runes[i+1] == '-' && runes[i+2] != ']' {
+					hi = runes[i+2]
+					i += 2
+				}
+				class.ranges = append(class.ranges, [2]rune{lo, hi})
+			}
+
+			if i >= len(runes) {
+				return nil, errors.New("unterminated character class in GLOB pattern")
+			}
+			elems = append(elems, class)
+		default:
+			elems = append(elems, patternElem{kind: patLiteral, r: runes[i]})
+		}
+	}
+	return elems, nil
+}
+
+// matchPattern matches the whole string. On a mismatch it backtracks to the
+// last sequence wildcard and lets it take one more character, which only needs
+// to remember a single position since every other element matches exactly one
+// character.
+func matchPattern(elems []patternElem, s []rune) bool {
+	pi, si := 0, 0
+	seqPi, seqSi := -1, 0
+	for si < len(s) {
+		switch {
+		case pi < len(elems) && elems[pi].kind == patSeq:
+			seqPi, seqSi = pi, si
+			pi++
+		case pi < len(elems) && elems[pi].matches(s[si]):
+			pi++
+			si++
+		case seqPi != -1:
+			seqSi++
+			pi, si = seqPi+1, seqSi
+		default:
+			return false
+		}
+	}
+
+	for pi < len(elems) && elems[pi].kind == patSeq {
+		pi++
+	}
+	return pi == len(elems)
+}
@@ -0,0 +1,438 @@
+package levelsql
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Tables keep the history of their column layouts so that ALTER TABLE never has
+// to rewrite existing rows. Every column gets a stable id and every change to the
+// stored layout creates a new schema version. Rows are stamped with the version
+// they were written in and are mapped to the current columns when they are read.
+
+const (
+	// the original catalog format starts with the 8 byte length of the first
+	// column name and rows start with the 8 byte length of their first cell, so
+	// their first byte is always zero. A leading 0xff marks the versioned formats.
+	versionedCatalogMarker byte = 0xff
+	versionedRowMarker     byte = 0xff
+)
+
+var errCorruptCatalog = errors.New("corrupt catalog entry")
+
+type table struct {
+	Name    string
+	Columns []string
+	Types   []string
+
+	// Key identifies the table in the keys of its rows and index entries, see
+	// newTableKey.
+	Key string
+
+	// Defaults holds the value of each column for rows that were written before
+	// the column existed.
+	Defaults  []value
+	ColumnIDs []int
+
+	Version      int
+	Versions     [][]int // column ids in stored order for every schema version
+	NextColumnID int
+
+	// PrimaryKey holds the ids of the key columns in key order. Rows of a table
+	// without one are stored under random keys.
+	PrimaryKey []int
+
+	Indexes     []index
+	NextIndexID int
+
+	layouts map[int][]int
+
+	// qualifiers holds the table name or alias of every column for the combined
+	// rows of a join. Without it every column belongs to Name.
+	qualifiers []string
+}
+
+// index is a secondary index of a table. Its entries are keyed by the encoded
+// values of Columns and hold the values of Columns and Include, so a query that
+// only needs those columns doesn't have to read the rows.
+type index struct {
+	Name    string
+	ID      int // keys the entries, it is never reused within a table
+	Unique  bool
+	Columns []int // column ids
+	Include []int
+}
+
+func (t *table) index(name string) int {
+	for i := range t.Indexes {
+		if t.Indexes[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *table) qualifier(i int) string {
+	if t.qualifiers != nil {
+		return t.qualifiers[i]
+	}
+	return t.Name
+}
+
+// fieldIndex resolves a possibly qualified column reference like "u.name" to the
+// index of the column, or -1 if there is no such column or more than one.
+func (t *table) fieldIndex(field string) int {
+	i, _ := t.resolveColumn(field)
+	return i
+}
+
+// resolveColumn is fieldIndex with an error for a name that more than one of the
+// columns has, like an unqualified id when two joined tables have an id.
+func (t *table) resolveColumn(field string) (int, error) {
+	qualifier, name := "", field
+	if i := strings.LastIndexByte(field, '.'); i != -1 {
+		qualifier, name = field[:i], field[i+1:]
+	}
+
+	found := -1
+	for i, col := range t.Columns {
+		if col == name && (qualifier == "" || t.qualifier(i) == qualifier) {
+			if found != -1 {
+				return -1, fmt.Errorf("ambiguous column: %s", field)
+			}
+			found = i
+		}
+	}
+	return found, nil
+}
+
+// initSchema gives the columns ids if the table doesn't have them yet. This is the
+// case for new tables and tables stored in the original catalog format.
+func (t *table) initSchema() {
+	if t.ColumnIDs != nil {
+		return
+	}
+
+	t.ColumnIDs = make([]int, len(t.Columns))
+	for i := range t.Columns {
+		t.ColumnIDs[i] = i
+	}
+	for len(t.Defaults) < len(t.Columns) {
+		t.Defaults = append(t.Defaults, value{ty: nullVal})
+	}
+
+	t.Version = 0
+	t.Versions = [][]int{append([]int(nil), t.ColumnIDs...)}
+	t.NextColumnID = len(t.Columns)
+}
+
+// positions returns the current index of every column id.
+func (t *table) positions(ids []int) []int {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	positions := make([]int, 0, len(ids))
+	for _, id := range ids {
+		for i, colID := range t.ColumnIDs {
+			if colID == id {
+				positions = append(positions, i)
+			}
+		}
+	}
+	return positions
+}
+
+// primaryKey returns the indexes of the primary key columns in key order.
+func (t *table) primaryKey() []int {
+	return t.positions(t.PrimaryKey)
+}
+
+func (t *table) columnIndex(name string) int {
+	for i, col := range t.Columns {
+		if col == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *table) newVersion() {
+	t.Versions = append(t.Versions, append([]int(nil), t.ColumnIDs...))
+	t.Version = len(t.Versions) - 1
+	t.layouts = nil
+}
+
+func (t *table) addColumn(name, kind string, def value) error {
+	if t.columnIndex(name) != -1 {
+		return fmt.Errorf("column already exists: %s", name)
+	}
+
+	t.initSchema()
+	t.Columns = append(t.Columns, name)
+	t.Types = append(t.Types, kind)
+	t.Defaults = append(t.Defaults, def)
+	t.ColumnIDs = append(t.ColumnIDs, t.NextColumnID)
+	t.NextColumnID++
+	t.newVersion()
+
+	return nil
+}
+
+func (t *table) dropColumn(name string) error {
+	idx := t.columnIndex(name)
+	if idx == -1 {
+		return fmt.Errorf("no such column: %s", name)
+	}
+
+	if len(t.Columns) == 1 {
+		return errors.New("cannot drop the only column of a table")
+	}
+
+	t.initSchema()
+	for _, id := range t.PrimaryKey {
+		if id == t.ColumnIDs[idx] {
+			return fmt.Errorf("cannot drop primary key column: %s", name)
+		}
+	}
+	for _, ix := range t.Indexes {
+		for _, id := range indexColumns(&ix) {
+			if id == t.ColumnIDs[idx] {
+				return fmt.Errorf("cannot drop column %s used by index %s", name, ix.Name)
+			}
+		}
+	}
+
+	t.Columns = append(t.Columns[:idx:idx], t.Columns[idx+1:]...)
+	t.Types = append(t.Types[:idx:idx], t.Types[idx+1:]...)
+	t.Defaults = append(t.Defaults[:idx:idx], t.Defaults[idx+1:]...)
+	t.ColumnIDs = append(t.ColumnIDs[:idx:idx], t.ColumnIDs[idx+1:]...)
+	t.newVersion()
+
+	return nil
+}
+
+// renameColumn doesn't change the stored layout, so it doesn't need a new version.
+func (t *table) renameColumn(from, to string) error {
+	idx := t.columnIndex(from)
+	if idx == -1 {
+		return fmt.Errorf("no such column: %s", from)
+	}
+
+	if t.columnIndex(to) != -1 {
+		return fmt.Errorf("column already exists: %s", to)
+	}
+
+	t.Columns[idx] = to
+	return nil
+}
+
+// layout returns for each stored cell of a row written in the given version the
+// index of the current column it belongs to, or -1 if the column was dropped. A
+// nil layout means that the cells are already in the current order.
+func (t *table) layout(version int) []int {
+	if t == nil || version == t.Version || version >= len(t.Versions) {
+		return nil
+	}
+
+	if m, ok := t.layouts[version]; ok {
+		return m
+	}
+
+	current := make(map[int]int, len(t.ColumnIDs))
+	for i, id := range t.ColumnIDs {
+		current[id] = i
+	}
+
+	m := make([]int, len(t.Versions[version]))
+	for i, id := range t.Versions[version] {
+		idx, ok := current[id]
+		if !ok {
+			idx = -1
+		}
+		m[i] = idx
+	}
+
+	if t.layouts == nil {
+		t.layouts = make(map[int][]int)
+	}
+	t.layouts[version] = m
+	return m
+}
+
+func appendUint(buf []byte, v uint64) []byte {
+	return binary.BigEndian.AppendUint64(buf, v)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = appendUint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+type byteReader struct {
+	buf    []byte
+	offset int
+	err    error
+}
+
+func (r *byteReader) uint() uint64 {
+	if r.err != nil || r.offset+8 > len(r.buf) {
+		r.err = errCorruptCatalog
+		return 0
+	}
+
+	v := binary.BigEndian.Uint64(r.buf[r.offset:])
+	r.offset += 8
+	return v
+}
+
+func (r *byteReader) bytes() []byte {
+	n := r.uint()
+	if r.err != nil || uint64(len(r.buf)-r.offset) < n {
+		r.err = errCorruptCatalog
+		return nil
+	}
+
+	b := r.buf[r.offset : r.offset+int(n)]
+	r.offset += int(n)
+	return b
+}
+
+func encodeTable(t *table) []byte {
+	t.initSchema()
+
+	value := []byte{versionedCatalogMarker}
+	value = appendUint(value, uint64(t.Version))
+	value = appendUint(value, uint64(t.NextColumnID))
+
+	value = appendUint(value, uint64(len(t.Columns)))
+	for i, column := range t.Columns {
+		value = appendBytes(value, []byte(column))
+		value = appendBytes(value, []byte(t.Types[i]))
+		value = appendUint(value, uint64(t.ColumnIDs[i]))
+		value = appendBytes(value, t.Defaults[i].bytes())
+	}
+
+	value = appendUint(value, uint64(len(t.Versions)))
+	for _, ids := range t.Versions {
+		value = appendUint(value, uint64(len(ids)))
+		for _, id := range ids {
+			value = appendUint(value, uint64(id))
+		}
+	}
+
+	value = appendIDs(value, t.PrimaryKey)
+
+	value = appendUint(value, uint64(t.NextIndexID))
+	value = appendUint(value, uint64(len(t.Indexes)))
+	for _, ix := range t.Indexes {
+		value = appendBytes(value, []byte(ix.Name))
+		value = appendUint(value, uint64(ix.ID))
+		unique := uint64(0)
+		if ix.Unique {
+			unique = 1
+		}
+		value = appendUint(value, unique)
+		value = appendIDs(value, ix.Columns)
+		value = appendIDs(value, ix.Include)
+	}
+
+	if t.Key == "" {
+		t.Key = newTableKey()
+	}
+	value = appendBytes(value, []byte(t.Key))
+
+	return value
+}
+
+func appendIDs(buf []byte, ids []int) []byte {
+	buf = appendUint(buf, uint64(len(ids)))
+	for _, id := range ids {
+		buf = appendUint(buf, uint64(id))
+	}
+	return buf
+}
+
+func (r *byteReader) ids() []int {
+	n := int(r.uint())
+	var ids []int
+	for i := 0; i < n && r.err == nil; i++ {
+		ids = append(ids, int(r.uint()))
+	}
+	return ids
+}
+
+func decodeTable(name string, value []byte) (*table, error) {
+	table := &table{
+		Name:    name,
+		Columns: make([]string, 0),
+		Types:   make([]string, 0),
+	}
+
+	if len(value) == 0 || value[0] != versionedCatalogMarker {
+		r := &byteReader{buf: value}
+		for r.offset < len(value) && r.err == nil {
+			table.Columns = append(table.Columns, string(r.bytes()))
+			table.Types = append(table.Types, string(r.bytes()))
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		table.initSchema()
+		table.Key = legacyTableKey(name)
+		return table, nil
+	}
+
+	r := &byteReader{buf: value, offset: 1}
+	table.Version = int(r.uint())
+	table.NextColumnID = int(r.uint())
+
+	columns := int(r.uint())
+	for i := 0; i < columns && r.err == nil; i++ {
+		table.Columns = append(table.Columns, string(r.bytes()))
+		table.Types = append(table.Types, string(r.bytes()))
+		table.ColumnIDs = append(table.ColumnIDs, int(r.uint()))
+		table.Defaults = append(table.Defaults, deserializeValue(r.bytes()))
+	}
+
+	versions := int(r.uint())
+	for i := 0; i < versions && r.err == nil; i++ {
+		ids := make([]int, int(r.uint()))
+		for j := range ids {
+			ids[j] = int(r.uint())
+		}
+		table.Versions = append(table.Versions, ids)
+	}
+
+	// catalogs written before primary keys and indexes end here
+	if r.err == nil && r.offset < len(value) {
+		table.PrimaryKey = r.ids()
+	}
+
+	if r.err == nil && r.offset < len(value) {
+		table.NextIndexID = int(r.uint())
+		indexes := int(r.uint())
+		for i := 0; i < indexes && r.err == nil; i++ {
+			ix := index{Name: string(r.bytes()), ID: int(r.uint())}
+			ix.Unique = r.uint() == 1
+			ix.Columns = r.ids()
+			ix.Include = r.ids()
+			table.Indexes = append(table.Indexes, ix)
+		}
+	}
+
+	// catalogs written before tables had keys end here
+	table.Key = legacyTableKey(name)
+	if r.err == nil && r.offset < len(value) {
+		table.Key = string(r.bytes())
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return table, nil
+}
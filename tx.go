@@ -0,0 +1,318 @@
+package levelsql
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/memdb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// kvReader is the read side of leveldb, which both the database and its
+// snapshots have.
+type kvReader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	Has(key []byte, ro *opt.ReadOptions) (bool, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+// kvStore is the part of leveldb that the storage reads and writes through.
+type kvStore interface {
+	kvReader
+	Put(key, value []byte, wo *opt.WriteOptions) error
+	Delete(key []byte, wo *opt.WriteOptions) error
+	Write(batch *leveldb.Batch, wo *opt.WriteOptions) error
+}
+
+// txStore is the kvStore of a statement or a transaction. Both commit and
+// rollback release what the store holds on to.
+type txStore interface {
+	kvStore
+	commit() error
+	rollback()
+}
+
+// The ways a transaction of the storage can read and write. Each of them reads
+// from a snapshot that is taken when it begins.
+const (
+	// txStatement buffers the writes of a single statement, they are only
+	// committed if the statement succeeds.
+	txStatement = iota
+	// txReadWrite buffers the writes until commit.
+	txReadWrite
+	// txReadOnly fails on every write.
+	txReadOnly
+)
+
+var errReadOnly = errors.New("cannot write in a read only transaction")
+
+// snapshotStore reads from a snapshot of the database, it is the store of a
+// read only transaction.
+type snapshotStore struct {
+	versionedReader
+	snap *leveldb.Snapshot
+}
+
+func newSnapshotStore(snap *leveldb.Snapshot) *snapshotStore {
+	return &snapshotStore{
+		versionedReader: versionedReader{r: snap, ts: latestTimestamp},
+		snap:            snap,
+	}
+}
+
+func (s *snapshotStore) Put(key, value []byte, wo *opt.WriteOptions) error {
+	return errReadOnly
+}
+
+func (s *snapshotStore) Delete(key []byte, wo *opt.WriteOptions) error {
+	return errReadOnly
+}
+
+func (s *snapshotStore) Write(batch *leveldb.Batch, wo *opt.WriteOptions) error {
+	return errReadOnly
+}
+
+func (s *snapshotStore) commit() error {
+	s.snap.Release()
+	return nil
+}
+
+func (s *snapshotStore) rollback() {
+	s.snap.Release()
+}
+
+// bufferedStore buffers the writes of a statement or a transaction in a batch
+// that is written to the database on commit. Reads see the buffered writes on
+// top of a snapshot of the database. The buffered writes are kept in a memdb to
+// read them back in key order, with a marker in front of the values like the
+// versions of a row.
+type bufferedStore struct {
+	snap    *leveldb.Snapshot // nil for a statement in a transaction
+	read    kvReader
+	parent  kvStore // where the batch is written on commit
+	batch   *leveldb.Batch
+	pending *memdb.DB
+}
+
+func newBufferedStore(clock *versionClock, snap *leveldb.Snapshot) *bufferedStore {
+	return &bufferedStore{
+		snap:    snap,
+		read:    &versionedReader{r: snap, ts: latestTimestamp},
+		parent:  newMVCCStore(clock),
+		batch:   new(leveldb.Batch),
+		pending: memdb.New(comparer.DefaultComparer, 0),
+	}
+}
+
+// newSavepoint buffers the writes of a statement in a transaction on top of the
+// store of the transaction. They are only added to the transaction when the
+// statement commits, so a statement that fails leaves nothing behind.
+func newSavepoint(parent kvStore) *bufferedStore {
+	return &bufferedStore{
+		read:    parent,
+		parent:  parent,
+		batch:   new(leveldb.Batch),
+		pending: memdb.New(comparer.DefaultComparer, 0),
+	}
+}
+
+func (t *bufferedStore) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	if value, err := t.pending.Get(key); err == nil {
+		if value[0] == markDeleted {
+			return nil, leveldb.ErrNotFound
+		}
+		return append([]byte(nil), value[1:]...), nil
+	}
+	return t.read.Get(key, ro)
+}
+
+func (t *bufferedStore) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
+	if value, err := t.pending.Get(key); err == nil {
+		return value[0] == markWritten, nil
+	}
+	return t.read.Has(key, ro)
+}
+
+// NewIterator merges the buffered writes in the range with the database. The
+// writes are copied first, so like a leveldb iterator it doesn't see the writes
+// made while iterating.
+func (t *bufferedStore) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	ti := &txIterator{db: t.read.NewIterator(slice, ro)}
+
+	pending := t.pending.NewIterator(slice)
+	for pending.Next() {
+		ti.keys = append(ti.keys, append([]byte(nil), pending.Key()...))
+		ti.values = append(ti.values, append([]byte(nil), pending.Value()...))
+	}
+	pending.Release()
+
+	return ti
+}
+
+func (t *bufferedStore) Put(key, value []byte, wo *opt.WriteOptions) error {
+	t.batch.Put(key, value)
+	return t.pending.Put(key, append([]byte{markWritten}, value...))
+}
+
+func (t *bufferedStore) Delete(key []byte, wo *opt.WriteOptions) error {
+	t.batch.Delete(key)
+	return t.pending.Put(key, []byte{markDeleted})
+}
+
+func (t *bufferedStore) Write(batch *leveldb.Batch, wo *opt.WriteOptions) error {
+	r := &txReplay{store: t}
+	if err := batch.Replay(r); err != nil {
+		return err
+	}
+	return r.err
+}
+
+// commit writes the batch, every row it writes to the database gets the same
+// version.
+func (t *bufferedStore) commit() error {
+	var err error
+	if t.batch.Len() > 0 {
+		err = t.parent.Write(t.batch, nil)
+	}
+	t.rollback()
+	return err
+}
+
+func (t *bufferedStore) rollback() {
+	if t.snap != nil {
+		t.snap.Release()
+	}
+	t.batch.Reset()
+	t.pending.Reset()
+}
+
+// txReplay adds the writes of a batch to a transaction.
+type txReplay struct {
+	store *bufferedStore
+	err   error
+}
+
+func (r *txReplay) Put(key, value []byte) {
+	if err := r.store.Put(key, value, nil); r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *txReplay) Delete(key []byte) {
+	if err := r.store.Delete(key, nil); r.err == nil {
+		r.err = err
+	}
+}
+
+// txIterator walks the keys of the database and the buffered writes of a
+// transaction in order. A buffered write hides the key in the database and a
+// buffered delete is skipped.
+type txIterator struct {
+	forwardIterator
+	db       iterator.Iterator
+	dbValid  bool
+	dbLoaded bool // db has been moved past the last key it returned
+
+	keys, values [][]byte
+	next         int
+}
+
+func (ti *txIterator) Next() bool {
+	for ti.err == nil {
+		if !ti.dbLoaded {
+			ti.dbValid = ti.db.Next()
+			ti.dbLoaded = true
+		}
+
+		hasPending := ti.next < len(ti.keys)
+		if !hasPending && !ti.dbValid {
+			break
+		}
+
+		if !hasPending || (ti.dbValid && bytes.Compare(ti.db.Key(), ti.keys[ti.next]) < 0) {
+			ti.key, ti.value = ti.db.Key(), ti.db.Value()
+			ti.dbLoaded = false
+			return true
+		}
+
+		key, value := ti.keys[ti.next], ti.values[ti.next]
+		ti.next++
+		if ti.dbValid && bytes.Equal(ti.db.Key(), key) {
+			ti.dbLoaded = false
+		}
+
+		if value[0] == markWritten {
+			ti.key, ti.value = key, value[1:]
+			return true
+		}
+	}
+
+	ti.key, ti.value = nil, nil
+	return false
+}
+
+func (ti *txIterator) Error() error {
+	if ti.err != nil {
+		return ti.err
+	}
+	return ti.db.Error()
+}
+
+func (ti *txIterator) Release() {
+	ti.db.Release()
+	ti.forwardIterator.Release()
+}
+
+// leveldbTx is the storage of a statement or a transaction, which reads and
+// writes the catalog through its store like the rows.
+type leveldbTx struct {
+	*leveldbStorage
+	store txStore
+}
+
+func (s *leveldbStorage) begin(mode int) (txStorage, error) {
+	snap, err := s.clock.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	var store txStore
+	if mode == txReadOnly {
+		store = newSnapshotStore(snap)
+	} else {
+		store = newBufferedStore(s.clock, snap)
+	}
+
+	return &leveldbTx{
+		leveldbStorage: &leveldbStorage{db: s.db, clock: s.clock, kv: store},
+		store:          store,
+	}, nil
+}
+
+// begin starts a statement in the transaction, which reads the writes of the
+// transaction and adds its own to them when it commits.
+func (tx *leveldbTx) begin(mode int) (txStorage, error) {
+	store := newSavepoint(tx.store)
+	return &leveldbTx{
+		leveldbStorage: &leveldbStorage{db: tx.db, clock: tx.clock, kv: store},
+		store:          store,
+	}, nil
+}
+
+func (tx *leveldbTx) commit() error {
+	return tx.store.commit()
+}
+
+func (tx *leveldbTx) rollback() {
+	tx.store.rollback()
+}
+
+// Close rolls the transaction back, the database stays open.
+func (tx *leveldbTx) Close() error {
+	tx.rollback()
+	return nil
+}